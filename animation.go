@@ -0,0 +1,259 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"image"
+	"image/color"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	// Registered so that we can animate saved frames in any format
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// Animator receives the frames of a run (in order) and writes an animation
+type Animator interface {
+	AddFrame(img image.Image) error
+	Close() error
+}
+
+// NewAnimator creates an animator for the given file name, where the
+// extension selects the format: .gif for an animated GIF or .avi for an
+// MJPEG AVI video. Only every skip'th frame is written, but the final frame
+// is always written on Close.
+func NewAnimator(fileName string, fps int, skip int) (Animator, error) {
+	if fps < 1 {
+		return nil, errors.New("Frame rate must be >= 1")
+	}
+	if skip < 1 {
+		return nil, errors.New("Frame skip must be >= 1")
+	}
+
+	var anim Animator
+	var err error
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".gif":
+		anim, err = newGIFAnimator(fileName, fps)
+	case ".avi":
+		anim, err = newAVIAnimator(fileName, fps)
+	default:
+		err = errors.New("Unknown animation format (use .gif or .avi): " + fileName)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &skipAnimator{anim: anim, skip: skip}, nil
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Frame skipping
+
+// skipAnimator only passes on every skip'th frame, and makes sure the last
+// frame it saw is written when it is closed
+type skipAnimator struct {
+	anim    Animator
+	skip    int
+	count   int
+	skipped image.Image
+}
+
+func (sa *skipAnimator) AddFrame(img image.Image) error {
+	sa.count++
+	if (sa.count-1)%sa.skip != 0 {
+		sa.skipped = img
+		return nil
+	}
+
+	sa.skipped = nil
+	return sa.anim.AddFrame(img)
+}
+
+func (sa *skipAnimator) Close() error {
+	if sa.skipped != nil {
+		if err := sa.anim.AddFrame(sa.skipped); err != nil {
+			sa.anim.Close()
+			return err
+		}
+	}
+	return sa.anim.Close()
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Palette quantization
+
+// colorBox is a set of colors for median cut quantization
+type colorBox []color.NRGBA
+
+// channel returns the given channel (0=R, 1=G, 2=B) of a color
+func channel(c color.NRGBA, ch int) uint8 {
+	switch ch {
+	case 0:
+		return c.R
+	case 1:
+		return c.G
+	}
+	return c.B
+}
+
+// widest returns the channel with the largest range in the box, and that range
+func (cb colorBox) widest() (int, int) {
+	bestCh, bestRng := 0, -1
+	for ch := 0; ch < 3; ch++ {
+		mn, mx := uint8(255), uint8(0)
+		for _, c := range cb {
+			v := channel(c, ch)
+			if v < mn {
+				mn = v
+			}
+			if v > mx {
+				mx = v
+			}
+		}
+		if rng := int(mx) - int(mn); rng > bestRng {
+			bestCh, bestRng = ch, rng
+		}
+	}
+	return bestCh, bestRng
+}
+
+// mean returns the average color of the box
+func (cb colorBox) mean() color.NRGBA {
+	var r, g, b int
+	for _, c := range cb {
+		r += int(c.R)
+		g += int(c.G)
+		b += int(c.B)
+	}
+	n := len(cb)
+	return color.NRGBA{R: uint8(r / n), G: uint8(g / n), B: uint8(b / n), A: 255}
+}
+
+// maxQuantizeSamples limits the pixels used to build a frame's palette
+const maxQuantizeSamples = 20000
+
+// quantize builds a palette of at most size colors for the image using
+// median cut: we repeatedly split the box with the widest channel range at
+// the median of that channel. Large images only use evenly spaced samples.
+func quantize(img image.Image, size int) color.Palette {
+	b := img.Bounds()
+	n := b.Dx() * b.Dy()
+	step := 1
+	if n > maxQuantizeSamples {
+		step = (n + maxQuantizeSamples - 1) / maxQuantizeSamples
+	}
+	pixels := make(colorBox, 0, (n+step-1)/step)
+	for i := 0; i < n; i += step {
+		c := img.At(b.Min.X+i%b.Dx(), b.Min.Y+i/b.Dx())
+		pixels = append(pixels, color.NRGBAModel.Convert(c).(color.NRGBA))
+	}
+	if len(pixels) < 1 {
+		return color.Palette{color.Black}
+	}
+
+	boxes := []colorBox{pixels}
+	for len(boxes) < size {
+		// Find the box we should split next
+		split, splitCh, splitRng := -1, 0, 0
+		for idx, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			if ch, rng := box.widest(); rng > splitRng {
+				split, splitCh, splitRng = idx, ch, rng
+			}
+		}
+		if split < 0 {
+			break // every box is a single color
+		}
+
+		box := boxes[split]
+		sort.Slice(box, func(i, j int) bool {
+			return channel(box[i], splitCh) < channel(box[j], splitCh)
+		})
+		mid := len(box) / 2
+		boxes[split] = box[:mid]
+		boxes = append(boxes, box[mid:])
+	}
+
+	pal := make(color.Palette, 0, len(boxes))
+	for _, box := range boxes {
+		pal = append(pal, box.mean())
+	}
+	return pal
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Animate command - create an animation from the saved frames of a run
+
+// frameFiles returns the saved generation images in a run directory, in order
+func frameFiles(dir string) ([]string, error) {
+	var files []string
+	for _, pattern := range []string{"gen-*.jpg", "gen-*.png"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+
+	// Generation numbers are zero padded, so file names sort correctly
+	sort.Strings(files)
+	return files, nil
+}
+
+// animateMain is the entry point for the animate command
+func animateMain(args []string) {
+	flags := flag.NewFlagSet("evoimage animate", flag.ExitOnError)
+	out := flags.String("out", "", "Animation file name: .gif or .avi (default is progress.gif in the run directory)")
+	fps := flags.Int("fps", 24, "Frames per second")
+	frameSkip := flags.Int("frameSkip", 1, "Only use every Nth frame (the last frame is always used)")
+	flags.Usage = func() {
+		log.Printf("Usage: evoimage animate [options] run_dir\n")
+		flags.PrintDefaults()
+	}
+
+	pcheck(flags.Parse(args))
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	dir := flags.Arg(0)
+	if len(*out) < 1 {
+		*out = filepath.Join(dir, "progress.gif")
+	}
+
+	files, err := frameFiles(dir)
+	pcheck(err)
+	if len(files) < 1 {
+		pcheck(errors.New("No frames (gen-*.jpg or gen-*.png) found in " + dir))
+	}
+
+	anim, err := NewAnimator(*out, *fps, *frameSkip)
+	pcheck(err)
+
+	log.Printf("Animating %d frames from %s to %s\n", len(files), dir, *out)
+	for _, fileName := range files {
+		img, err := loadImage(fileName)
+		pcheck(err)
+		pcheck(anim.AddFrame(img))
+	}
+	pcheck(anim.Close())
+}
+
+// loadImage reads an image file in any registered format
+func loadImage(fileName string) (image.Image, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	return img, err
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+)
+
+// aviAnimator writes an MJPEG AVI: every frame is a JPEG in its own chunk.
+// The layout is:
+//
+//	RIFF 'AVI '
+//	  LIST 'hdrl'
+//	    'avih' (main header)
+//	    LIST 'strl'
+//	      'strh' (stream header)
+//	      'strf' (BITMAPINFOHEADER)
+//	  LIST 'movi'
+//	    '00dc' (one JPEG per frame)
+//	  'idx1' (index of frames)
+//
+// Sizes and frame counts aren't known until the end, so we write
+// placeholders and patch them in Close.
+type aviAnimator struct {
+	f      *os.File
+	fps    int
+	width  int
+	height int
+	pos    int64 // current write position
+
+	// Offsets of the fields we patch in Close
+	riffSize    int64
+	totalFrames int64
+	maxBytes    int64
+	bufSize     int64
+	strhLength  int64
+	strhBufSize int64
+	moviSize    int64
+	moviStart   int64 // position of the 'movi' fourcc
+
+	index    []aviIndexEntry
+	maxFrame int
+}
+
+type aviIndexEntry struct {
+	offset uint32
+	size   uint32
+}
+
+const (
+	aviHasIndex = 0x10 // AVIF_HASINDEX
+	aviKeyFrame = 0x10 // AVIIF_KEYFRAME
+)
+
+func newAVIAnimator(fileName string, fps int) (*aviAnimator, error) {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return &aviAnimator{f: f, fps: fps}, nil
+}
+
+// write writes little endian values and tracks our position
+func (aa *aviAnimator) write(vals ...interface{}) error {
+	for _, v := range vals {
+		var err error
+		switch tv := v.(type) {
+		case string: // A fourcc
+			_, err = io.WriteString(aa.f, tv)
+			aa.pos += int64(len(tv))
+		case []byte:
+			_, err = aa.f.Write(tv)
+			aa.pos += int64(len(tv))
+		default:
+			err = binary.Write(aa.f, binary.LittleEndian, v)
+			aa.pos += int64(binary.Size(v))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// patch overwrites the uint32 at the given offset
+func (aa *aviAnimator) patch(offset int64, val uint32) error {
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, val)
+	_, err := aa.f.WriteAt(buf, offset)
+	return err
+}
+
+// writeHeader writes everything up to the start of the frame data
+func (aa *aviAnimator) writeHeader() error {
+	w, h := uint32(aa.width), uint32(aa.height)
+
+	// RIFF header
+	if err := aa.write("RIFF"); err != nil {
+		return err
+	}
+	aa.riffSize = aa.pos
+	if err := aa.write(uint32(0), "AVI "); err != nil {
+		return err
+	}
+
+	// hdrl list: 4 + avih (8+56) + strl list (8 + 4 + strh (8+56) + strf (8+40))
+	if err := aa.write("LIST", uint32(4+64+8+4+64+48), "hdrl"); err != nil {
+		return err
+	}
+
+	// Main AVI header
+	if err := aa.write("avih", uint32(56), uint32(1000000/aa.fps)); err != nil {
+		return err
+	}
+	aa.maxBytes = aa.pos
+	if err := aa.write(uint32(0), uint32(0), uint32(aviHasIndex)); err != nil {
+		return err
+	}
+	aa.totalFrames = aa.pos
+	if err := aa.write(uint32(0), uint32(0), uint32(1)); err != nil {
+		return err
+	}
+	aa.bufSize = aa.pos
+	if err := aa.write(uint32(0), w, h, [4]uint32{}); err != nil {
+		return err
+	}
+
+	// Stream list: one video stream
+	if err := aa.write("LIST", uint32(4+64+48), "strl"); err != nil {
+		return err
+	}
+	if err := aa.write(
+		"strh", uint32(56), "vids", "MJPG",
+		uint32(0),      // flags
+		uint16(0),      // priority
+		uint16(0),      // language
+		uint32(0),      // initial frames
+		uint32(1),      // scale
+		uint32(aa.fps), // rate (so fps = rate / scale)
+		uint32(0),      // start
+	); err != nil {
+		return err
+	}
+	aa.strhLength = aa.pos
+	if err := aa.write(uint32(0)); err != nil {
+		return err
+	}
+	aa.strhBufSize = aa.pos
+	if err := aa.write(
+		uint32(0),          // suggested buffer size
+		uint32(0xFFFFFFFF), // quality (default)
+		uint32(0),          // sample size (0 = varies)
+		[4]uint16{0, 0, uint16(w), uint16(h)},
+	); err != nil {
+		return err
+	}
+
+	// Stream format: BITMAPINFOHEADER
+	if err := aa.write(
+		"strf", uint32(40),
+		uint32(40), int32(w), int32(h),
+		uint16(1),  // planes
+		uint16(24), // bits per pixel
+		"MJPG",
+		uint32(w*h*3), // image size
+		[4]uint32{},   // pixels per meter and color counts
+	); err != nil {
+		return err
+	}
+
+	// Frame data list
+	if err := aa.write("LIST"); err != nil {
+		return err
+	}
+	aa.moviSize = aa.pos
+	if err := aa.write(uint32(0)); err != nil {
+		return err
+	}
+	aa.moviStart = aa.pos
+	return aa.write("movi")
+}
+
+func (aa *aviAnimator) AddFrame(img image.Image) error {
+	if aa.pos == 0 {
+		b := img.Bounds()
+		aa.width, aa.height = b.Dx(), b.Dy()
+		if err := aa.writeHeader(); err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	size := len(data)
+
+	aa.index = append(aa.index, aviIndexEntry{
+		offset: uint32(aa.pos - aa.moviStart),
+		size:   uint32(size),
+	})
+	if size > aa.maxFrame {
+		aa.maxFrame = size
+	}
+
+	// Chunks are padded to an even size (which isn't included in the size)
+	if size%2 == 1 {
+		data = append(data, 0)
+	}
+	return aa.write("00dc", uint32(size), data)
+}
+
+func (aa *aviAnimator) Close() error {
+	if aa.pos == 0 {
+		return aa.f.Close() // No frames, so no video
+	}
+
+	moviEnd := aa.pos
+
+	// Index
+	err := aa.write("idx1", uint32(16*len(aa.index)))
+	for _, e := range aa.index {
+		if err == nil {
+			err = aa.write("00dc", uint32(aviKeyFrame), e.offset, e.size)
+		}
+	}
+
+	frames := uint32(len(aa.index))
+	maxBytes := uint32(aa.maxFrame * aa.fps)
+	for _, p := range []struct {
+		offset int64
+		val    uint32
+	}{
+		{aa.riffSize, uint32(aa.pos - 8)},
+		{aa.totalFrames, frames},
+		{aa.maxBytes, maxBytes},
+		{aa.bufSize, uint32(aa.maxFrame)},
+		{aa.strhLength, frames},
+		{aa.strhBufSize, uint32(aa.maxFrame)},
+		{aa.moviSize, uint32(moviEnd - aa.moviStart)},
+	} {
+		if err == nil {
+			err = aa.patch(p.offset, p.val)
+		}
+	}
+
+	if cerr := aa.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
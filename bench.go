@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"text/tabwriter"
+
+	"github.com/CraigKelly/evoimage/evo"
+)
+
+// benchResult is one benchmark run by the bench command
+type benchResult struct {
+	*evo.Benchmark
+	Result testing.BenchmarkResult
+}
+
+// OpsPerSec is how many ops ran per second
+func (br benchResult) OpsPerSec() float64 {
+	if br.Result.T <= 0 {
+		return 0.0
+	}
+	return float64(br.Result.N) / br.Result.T.Seconds()
+}
+
+// runBenchmark times a benchmark from the evo package
+func runBenchmark(bm *evo.Benchmark) benchResult {
+	return benchResult{
+		Benchmark: bm,
+		Result: testing.Benchmark(func(b *testing.B) {
+			b.ReportAllocs()
+			b.ResetTimer()
+			bm.Run(b.N)
+		}),
+	}
+}
+
+// parseInts parses a comma separated list of positive ints
+func parseInts(s string) ([]int, error) {
+	var vals []int
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); len(part) < 1 {
+			continue
+		}
+		v, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, err
+		}
+		if v < 1 {
+			return nil, fmt.Errorf("Invalid value %d - must be at least 1", v)
+		}
+		vals = append(vals, v)
+	}
+	return vals, nil
+}
+
+// joinInts is the reverse of parseInts (for flag defaults)
+func joinInts(vals []int) string {
+	parts := make([]string, len(vals))
+	for i, v := range vals {
+		parts[i] = strconv.Itoa(v)
+	}
+	return strings.Join(parts, ",")
+}
+
+// writeBenchTable prints the results as an aligned table
+func writeBenchTable(results []benchResult) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "Op\tSize\tGenes\tCores\tns/op\tEvals/s\tEvals/s/core\tAllocs/op\tBytes/op\t\n")
+	for _, br := range results {
+		evals, perCore := "-", "-"
+		if br.EvalsPerOp > 0 {
+			eps := br.OpsPerSec() * float64(br.EvalsPerOp)
+			evals = fmt.Sprintf("%.1f", eps)
+			perCore = fmt.Sprintf("%.1f", eps/float64(br.Cores))
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%s\t%s\t%d\t%d\t\n",
+			br.Op, br.Size, br.Genes, br.Cores,
+			br.Result.NsPerOp(), evals, perCore,
+			br.Result.AllocsPerOp(), br.Result.AllocedBytesPerOp(),
+		)
+	}
+	tw.Flush()
+}
+
+// benchMain implements the bench command: it runs the evo benchmarks and
+// prints throughput and allocations
+func benchMain(args []string) {
+	flags := flag.NewFlagSet("evoimage bench", flag.ExitOnError)
+	sizeList := flags.String("sizes", joinInts(evo.BenchSizes), "Comma separated target sizes in pixels (targets are square)")
+	geneList := flags.String("genes", joinInts(evo.BenchGenes), "Comma separated gene counts")
+	opList := flags.String("ops", strings.Join(evo.BenchOps, ","), "Comma separated operations to benchmark")
+	popSize := flags.Int("popSize", 50, "Population size for evalpop")
+	coreCount := flags.Int("cores", 0, "Number of cores for evalpop (0 uses all of them)")
+	flags.Usage = func() {
+		log.Printf("Usage: evoimage bench [options]\n")
+		flags.PrintDefaults()
+	}
+
+	pcheck(flags.Parse(args))
+	sizes, err := parseInts(*sizeList)
+	pcheck(err)
+	genes, err := parseInts(*geneList)
+	pcheck(err)
+	if len(sizes) < 1 || len(genes) < 1 {
+		pcheck(errors.New("Need at least one size and gene count"))
+	}
+	if *popSize < 1 {
+		pcheck(errors.New("Population size must be >= 1"))
+	}
+	ops := strings.Split(*opList, ",")
+	for i, op := range ops {
+		ops[i] = strings.TrimSpace(op)
+		known := false
+		for _, name := range evo.BenchOps {
+			known = known || ops[i] == name
+		}
+		if !known {
+			pcheck(fmt.Errorf("Unknown op %s - must be one of %s", op, strings.Join(evo.BenchOps, ",")))
+		}
+	}
+
+	// The targets log their colors when first used, which is just noise here
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	var results []benchResult
+	for _, op := range ops {
+		for _, size := range sizes {
+			for _, gc := range genes {
+				bm, err := evo.NewBenchmark(op, size, gc, *popSize, *coreCount)
+				pcheck(err)
+				fmt.Fprintf(os.Stderr, "Running %s size=%d genes=%d\n", op, size, gc)
+				results = append(results, runBenchmark(bm))
+			}
+		}
+	}
+
+	writeBenchTable(results)
+}
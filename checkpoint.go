@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+
+	"github.com/CraigKelly/evoimage/evo"
+)
+
+// checkpoint is everything needed to resume a run
+type checkpoint struct {
+	RunID       string             `json:"runId"`
+	Generation  int                `json:"generation"`
+	StallCount  int                `json:"stallCount"`
+	BestFitness float64            `json:"bestFitness,omitempty"` // Best of the previous generation (0 in old checkpoints)
+	Params      map[string]float64 `json:"params"`
+	Population  []evo.GenomeJSON   `json:"population"`
+}
+
+// saveCheckpoint writes the population and current parameters
+func saveCheckpoint(fileName string, runID string, generation int, stallCount int, bestFitness float64, params map[string]float64, pop evo.Population) error {
+	cp := checkpoint{
+		RunID:       runID,
+		Generation:  generation,
+		StallCount:  stallCount,
+		BestFitness: bestFitness,
+		Params:      params,
+		Population:  make([]evo.GenomeJSON, 0, len(pop)),
+	}
+	for _, ind := range pop {
+		cp.Population = append(cp.Population, ind.ToJSON())
+	}
+	return writeJSON(fileName, cp, "")
+}
+
+// loadCheckpoint reads a checkpoint and recreates its population
+func loadCheckpoint(fileName string, src *evo.ImageTarget) (*checkpoint, evo.Population, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cp := &checkpoint{}
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, nil, err
+	}
+	if len(cp.Population) < 1 {
+		return nil, nil, errors.New("Checkpoint has an empty population")
+	}
+
+	pop := make(evo.Population, 0, len(cp.Population))
+	for _, gj := range cp.Population {
+		ind, err := evo.IndividualFromJSON(src, gj)
+		if err != nil {
+			return nil, nil, err
+		}
+		pop = append(pop, ind)
+	}
+	return cp, pop, nil
+}
+
+// writeJSON writes v as JSON (indented if indent isn't empty) using a temp
+// file and rename, so that readers never see a partial file
+func writeJSON(fileName string, v interface{}, indent string) error {
+	var data []byte
+	var err error
+	if len(indent) > 0 {
+		data, err = json.MarshalIndent(v, "", indent)
+	} else {
+		data, err = json.Marshal(v)
+	}
+	if err != nil {
+		return err
+	}
+
+	tmpName := fileName + ".tmp"
+	if err := ioutil.WriteFile(tmpName, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, fileName)
+}
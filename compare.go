@@ -0,0 +1,603 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// Loading runs
+
+// logRow is a single generation from a run log
+type logRow struct {
+	Gen   int
+	Evals float64 // 0 if the log doesn't record evaluations
+	Secs  float64 // Wall time since the first generation
+	Best  float64
+	Avg   float64
+	Worst float64
+	Cols  map[string]float64 // Every numeric column
+}
+
+// runData is a run loaded from a run directory or archive
+type runData struct {
+	Name     string
+	Group    string // Runs in the same group are repeats of one configuration
+	Manifest *Manifest
+	Rows     []logRow
+}
+
+// parseRunLog reads a CSV run log. Logs written before run directories can
+// contain several runs (each starting with a title line): we keep the last.
+func parseRunLog(r io.Reader) ([]logRow, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1 // Title lines change over versions
+
+	var rows []logRow
+	var cols map[string]int
+	var start time.Time
+
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) < 1 {
+			continue
+		}
+
+		if rec[0] == "Gen" {
+			cols = make(map[string]int)
+			for idx, name := range rec {
+				cols[name] = idx
+			}
+			rows = nil
+			continue
+		}
+		if cols == nil {
+			return nil, errors.New("Log has no title line")
+		}
+
+		row := logRow{Cols: make(map[string]float64)}
+		for name, idx := range cols {
+			if idx >= len(rec) {
+				continue
+			}
+			if name == "Timestamp" {
+				ts, err := time.ParseInLocation("2006-01-02 15:04:05", rec[idx], time.Local)
+				if err == nil {
+					if len(rows) == 0 {
+						start = ts
+					}
+					row.Secs = ts.Sub(start).Seconds()
+				}
+				continue
+			}
+			if val, err := strconv.ParseFloat(rec[idx], 64); err == nil {
+				row.Cols[name] = val
+			}
+		}
+		row.Gen = int(row.Cols["Gen"])
+		row.Evals = row.Cols["Evals"]
+		row.Best = row.Cols["Best"]
+		row.Avg = row.Cols["Avg"]
+		row.Worst = row.Cols["Worst"]
+		rows = append(rows, row)
+	}
+
+	if len(rows) < 1 {
+		return nil, errors.New("Log has no generations")
+	}
+	return rows, nil
+}
+
+// loadRunDir loads a run directory (log.csv and manifest.json)
+func loadRunDir(dir string) (*runData, error) {
+	f, err := os.Open(filepath.Join(dir, "log.csv"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rows, err := parseRunLog(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", dir, err)
+	}
+
+	run := &runData{Name: filepath.Base(filepath.Clean(dir)), Rows: rows}
+	if m, err := LoadManifest(filepath.Join(dir, "manifest.json")); err == nil {
+		run.Manifest = m
+		run.Name = m.RunID
+	}
+	return run, nil
+}
+
+// loadRunArchive loads a run from a .tar.gz archive. We handle archived run
+// directories as well as the old cmpruns archives (with an <image>-log.csv
+// and no manifest).
+func loadRunArchive(fileName string) (*runData, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	name := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(fileName), ".gz"), ".tar")
+	run := &runData{Name: name}
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		base := filepath.Base(hdr.Name)
+		switch {
+		case base == "manifest.json":
+			data, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+			m := &Manifest{}
+			if err := json.Unmarshal(data, m); err != nil {
+				return nil, fmt.Errorf("%s: %v", fileName, err)
+			}
+			run.Manifest = m
+			run.Name = m.RunID
+		case base == "log.csv" || strings.HasSuffix(base, "-log.csv"):
+			run.Rows, err = parseRunLog(tr)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", fileName, err)
+			}
+		}
+	}
+
+	if len(run.Rows) < 1 {
+		return nil, errors.New(fileName + ": no run log found")
+	}
+	return run, nil
+}
+
+// loadRuns loads every run named on the command line. A name can be a run
+// directory, a directory of run directories (like a sweep), or an archive.
+func loadRuns(names []string) ([]*runData, error) {
+	var runs []*runData
+	for _, name := range names {
+		if strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz") {
+			run, err := loadRunArchive(name)
+			if err != nil {
+				return nil, err
+			}
+			runs = append(runs, run)
+			continue
+		}
+
+		if _, err := os.Stat(filepath.Join(name, "log.csv")); err == nil {
+			run, err := loadRunDir(name)
+			if err != nil {
+				return nil, err
+			}
+			runs = append(runs, run)
+			continue
+		}
+
+		logs, err := filepath.Glob(filepath.Join(name, "*", "log.csv"))
+		if err != nil {
+			return nil, err
+		}
+		if len(logs) < 1 {
+			return nil, errors.New(name + ": not a run directory, directory of runs, or archive")
+		}
+		sort.Strings(logs)
+		for _, logName := range logs {
+			run, err := loadRunDir(filepath.Dir(logName))
+			if err != nil {
+				return nil, err
+			}
+			runs = append(runs, run)
+		}
+	}
+	return runs, nil
+}
+
+// groupIgnore are the parameters that don't change what a run computes, so
+// runs that only differ in these are repeats of the same configuration
+var groupIgnore = func() map[string]bool {
+	ignore := map[string]bool{"seed": true}
+	for _, name := range outputFlags {
+		ignore[name] = true
+	}
+	return ignore
+}()
+
+// assignGroups groups runs by configuration. The group name lists only the
+// parameters that vary between the runs being compared. Runs without a
+// manifest are their own group.
+func assignGroups(runs []*runData) {
+	values := make(map[string]map[string]bool)
+	for _, run := range runs {
+		if run.Manifest == nil {
+			continue
+		}
+		for k, v := range run.Manifest.Params {
+			if groupIgnore[k] {
+				continue
+			}
+			if values[k] == nil {
+				values[k] = make(map[string]bool)
+			}
+			values[k][v] = true
+		}
+	}
+
+	var varying []string
+	for k, vals := range values {
+		if len(vals) > 1 {
+			varying = append(varying, k)
+		}
+	}
+	sort.Strings(varying)
+
+	for _, run := range runs {
+		if run.Manifest == nil {
+			run.Group = run.Name
+			continue
+		}
+
+		parts := make([]string, 0, len(varying))
+		for _, k := range varying {
+			v := run.Manifest.Params[k]
+			if k == "image" {
+				v = filepath.Base(v)
+			}
+			parts = append(parts, k+"="+v)
+		}
+		run.Group = strings.Join(parts, ",")
+		if len(run.Group) < 1 {
+			run.Group = "all"
+		}
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Aligning runs
+
+// axisValue returns the x value of a row for the given axis: gen, evals or time
+func axisValue(row logRow, axis string) float64 {
+	switch axis {
+	case "evals":
+		return row.Evals
+	case "time":
+		return row.Secs
+	}
+	return float64(row.Gen)
+}
+
+// bestAt returns the best fitness seen at or before x. Runs that ended before
+// x keep their final best.
+func (run *runData) bestAt(x float64, axis string) float64 {
+	best := math.Inf(1)
+	for _, row := range run.Rows {
+		if axisValue(row, axis) > x {
+			break
+		}
+		best = math.Min(best, row.Best)
+	}
+	return best
+}
+
+// finalFitness is the best fitness of the last generation
+func (run *runData) finalFitness() float64 {
+	return run.Rows[len(run.Rows)-1].Best
+}
+
+// bestFitness is the best fitness of any generation
+func (run *runData) bestFitness() float64 {
+	best := math.Inf(1)
+	for _, row := range run.Rows {
+		best = math.Min(best, row.Best)
+	}
+	return best
+}
+
+// timeTo returns the axis value where the run first reached the threshold
+func (run *runData) timeTo(threshold float64, axis string) (float64, bool) {
+	for _, row := range run.Rows {
+		if row.Best <= threshold {
+			return axisValue(row, axis), true
+		}
+	}
+	return 0.0, false
+}
+
+// curvePoint is an aggregate of a group of runs at one point on the axis
+type curvePoint struct {
+	X      float64
+	N      int
+	Mean   float64
+	Median float64
+	Lo     float64 // 95% confidence band for the mean
+	Hi     float64
+}
+
+// groupCurve returns the mean/median best-so-far curve for runs at points
+// evenly spaced x values
+func groupCurve(runs []*runData, axis string, points int) []curvePoint {
+	maxX := 0.0
+	for _, run := range runs {
+		maxX = math.Max(maxX, axisValue(run.Rows[len(run.Rows)-1], axis))
+	}
+
+	curve := make([]curvePoint, 0, points)
+	for p := 0; p < points; p++ {
+		x := maxX * float64(p) / float64(points-1)
+		var vals []float64
+		for _, run := range runs {
+			if v := run.bestAt(x, axis); !math.IsInf(v, 1) {
+				vals = append(vals, v)
+			}
+		}
+		if len(vals) < 1 {
+			continue
+		}
+
+		mean, sd := meanStdDev(vals)
+		half := tQuantile975(len(vals)-1) * sd / math.Sqrt(float64(len(vals)))
+		curve = append(curve, curvePoint{
+			X:      x,
+			N:      len(vals),
+			Mean:   mean,
+			Median: median(vals),
+			Lo:     mean - half,
+			Hi:     mean + half,
+		})
+	}
+	return curve
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Compare command
+
+// parseThresholds parses a comma separated list of fitness values
+func parseThresholds(s string) ([]float64, error) {
+	var vals []float64
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); len(part) < 1 {
+			continue
+		}
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, v)
+	}
+	return vals, nil
+}
+
+// fmtFloat formats a value for a report, using - for missing values
+func fmtFloat(v float64, ok bool, prec int) string {
+	if !ok || math.IsNaN(v) || math.IsInf(v, 0) {
+		return "-"
+	}
+	return strconv.FormatFloat(v, 'f', prec, 64)
+}
+
+// groupRuns returns the group names (sorted) and the runs in each group
+func groupRuns(runs []*runData) ([]string, map[string][]*runData) {
+	groups := make(map[string][]*runData)
+	var names []string
+	for _, run := range runs {
+		if _, ok := groups[run.Group]; !ok {
+			names = append(names, run.Group)
+		}
+		groups[run.Group] = append(groups[run.Group], run)
+	}
+	sort.Strings(names)
+	return names, groups
+}
+
+// compareMain is the entry point for the compare command
+func compareMain(args []string) {
+	flags := flag.NewFlagSet("evoimage compare", flag.ExitOnError)
+	axis := flags.String("by", "gen", "Align runs by gen, evals or time (seconds)")
+	thresholdList := flags.String("thresholds", "30,20,10", "Comma separated fitness thresholds to report time to reach")
+	curves := flags.String("curves", "", "Write mean/median curves with 95% confidence bands to this CSV file")
+	points := flags.Int("points", 200, "Number of points in each curve")
+	stats := flags.Bool("stats", false, "Print significance tests between groups as Markdown")
+	statsMD := flags.String("statsMD", "", "Write significance tests between groups to this Markdown file")
+	statsCSV := flags.String("statsCSV", "", "Write significance tests between groups to this CSV file")
+	alpha := flags.Float64("alpha", 0.05, "Significance level used to mark results")
+	flags.Usage = func() {
+		log.Printf("Usage: evoimage compare [options] run_dir|runs_dir|archive.tar.gz ...\n")
+		flags.PrintDefaults()
+	}
+
+	pcheck(flags.Parse(args))
+	if flags.NArg() < 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if *axis != "gen" && *axis != "evals" && *axis != "time" {
+		pcheck(errors.New("Invalid axis - must be gen, evals or time"))
+	}
+	if *points < 2 {
+		pcheck(errors.New("Need at least 2 points per curve"))
+	}
+	if *alpha <= 0.0 || *alpha >= 1.0 {
+		pcheck(errors.New("Alpha must be between 0 and 1"))
+	}
+	thresholds, err := parseThresholds(*thresholdList)
+	pcheck(err)
+
+	runs, err := loadRuns(flags.Args())
+	pcheck(err)
+
+	if *axis == "evals" {
+		// Old logs didn't record evaluations
+		kept := runs[:0]
+		for _, run := range runs {
+			if run.Rows[len(run.Rows)-1].Evals > 0 {
+				kept = append(kept, run)
+			} else {
+				log.Printf("Skipping %s: log has no evaluation counts\n", run.Name)
+			}
+		}
+		runs = kept
+	}
+	if len(runs) < 1 {
+		pcheck(errors.New("No runs to compare"))
+	}
+	assignGroups(runs)
+
+	// Per run report
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	header := []string{"Run", "Group", "Gens", "Evals", "Secs", "Final", "Best"}
+	for _, t := range thresholds {
+		header = append(header, fmt.Sprintf("To%v", t))
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, run := range runs {
+		last := run.Rows[len(run.Rows)-1]
+		cols := []string{
+			run.Name,
+			run.Group,
+			fmt.Sprintf("%d", last.Gen+1),
+			fmtFloat(last.Evals, last.Evals > 0, 0),
+			fmtFloat(last.Secs, true, 0),
+			fmtFloat(run.finalFitness(), true, 4),
+			fmtFloat(run.bestFitness(), true, 4),
+		}
+		for _, t := range thresholds {
+			v, ok := run.timeTo(t, *axis)
+			cols = append(cols, fmtFloat(v, ok, 1))
+		}
+		fmt.Fprintln(tw, strings.Join(cols, "\t"))
+	}
+	tw.Flush()
+	fmt.Println()
+
+	// Per group report
+	names, groups := groupRuns(runs)
+	header = []string{"Group", "Runs", "FinalMean", "FinalSD", "FinalMedian", "BestMin"}
+	for _, t := range thresholds {
+		header = append(header, fmt.Sprintf("To%vMean", t), fmt.Sprintf("To%vHit", t))
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, name := range names {
+		grp := groups[name]
+		finals := make([]float64, 0, len(grp))
+		bestMin := math.Inf(1)
+		for _, run := range grp {
+			finals = append(finals, run.finalFitness())
+			bestMin = math.Min(bestMin, run.bestFitness())
+		}
+		mean, sd := meanStdDev(finals)
+		cols := []string{
+			name,
+			fmt.Sprintf("%d", len(grp)),
+			fmtFloat(mean, true, 4),
+			fmtFloat(sd, true, 4),
+			fmtFloat(median(finals), true, 4),
+			fmtFloat(bestMin, true, 4),
+		}
+		for _, t := range thresholds {
+			var times []float64
+			for _, run := range grp {
+				if v, ok := run.timeTo(t, *axis); ok {
+					times = append(times, v)
+				}
+			}
+			tm, _ := meanStdDev(times)
+			cols = append(cols, fmtFloat(tm, len(times) > 0, 1), fmt.Sprintf("%d/%d", len(times), len(grp)))
+		}
+		fmt.Fprintln(tw, strings.Join(cols, "\t"))
+	}
+	tw.Flush()
+
+	if len(*curves) > 0 {
+		pcheck(writeCurves(*curves, *axis, names, groups, *points))
+		log.Printf("Wrote curves to %s\n", *curves)
+	}
+
+	if *stats || len(*statsMD) > 0 || len(*statsCSV) > 0 {
+		if len(names) < 2 {
+			pcheck(errors.New("Significance tests need at least 2 groups"))
+		}
+		rep := newSigReport(names, groups, *alpha)
+		if *stats {
+			fmt.Println()
+			rep.WriteMarkdown(os.Stdout)
+		}
+		if len(*statsMD) > 0 {
+			f, err := os.Create(*statsMD)
+			pcheck(err)
+			rep.WriteMarkdown(f)
+			pcheck(f.Close())
+			log.Printf("Wrote significance tests to %s\n", *statsMD)
+		}
+		if len(*statsCSV) > 0 {
+			pcheck(rep.WriteCSV(*statsCSV))
+			log.Printf("Wrote significance tests to %s\n", *statsCSV)
+		}
+	}
+}
+
+// writeCurves writes the aggregate curve of every group as CSV
+func writeCurves(fileName string, axis string, names []string, groups map[string][]*runData, points int) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"Group", axis, "N", "Mean", "Median", "Lo95", "Hi95"})
+	for _, name := range names {
+		for _, pt := range groupCurve(groups[name], axis, points) {
+			w.Write([]string{
+				name,
+				strconv.FormatFloat(pt.X, 'f', -1, 64),
+				fmt.Sprintf("%d", pt.N),
+				fmt.Sprintf("%.5f", pt.Mean),
+				fmt.Sprintf("%.5f", pt.Median),
+				fmt.Sprintf("%.5f", pt.Lo),
+				fmt.Sprintf("%.5f", pt.Hi),
+			})
+		}
+	}
+	w.Flush()
+
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseRunLog(t *testing.T) {
+	// An old style log with two runs: only the last is kept
+	log := strings.Join([]string{
+		"Gen,Best,Worst,Avg,Timestamp",
+		"1,90,99,95,2020-01-02 03:04:05",
+		"Gen,Evals,Best,Worst,Avg,Timestamp",
+		"1,10,80,99,90,2020-01-02 03:04:05",
+		"2,20,70,98,85,2020-01-02 03:04:07",
+		"3,30,60,97,80,2020-01-02 03:04:10",
+		"",
+	}, "\n")
+	rows, err := parseRunLog(strings.NewReader(log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("Expected 3 rows from the last run, got %d", len(rows))
+	}
+	last := rows[2]
+	if last.Gen != 3 || last.Evals != 30 || last.Best != 60 || last.Worst != 97 || last.Avg != 80 {
+		t.Errorf("Bad last row %+v", last)
+	}
+	for i, secs := range []float64{0, 2, 5} {
+		if rows[i].Secs != secs {
+			t.Errorf("Row %d is at %f secs, expected %f", i, rows[i].Secs, secs)
+		}
+	}
+
+	for _, bad := range []string{"1,2,3\n", "Gen,Best\n", ""} {
+		if _, err := parseRunLog(strings.NewReader(bad)); err == nil {
+			t.Errorf("Log %q was accepted", bad)
+		}
+	}
+}
+
+// testRun is a run with the given params and best fitness per generation
+func testRun(name string, params map[string]string, best ...float64) *runData {
+	run := &runData{Name: name}
+	if params != nil {
+		run.Manifest = &Manifest{RunID: name, Params: params}
+	}
+	for i, b := range best {
+		run.Rows = append(run.Rows, logRow{Gen: i + 1, Evals: float64(10 * (i + 1)), Best: b})
+	}
+	return run
+}
+
+func TestAssignGroups(t *testing.T) {
+	params := func(pop string, extra map[string]string) map[string]string {
+		p := map[string]string{"popSize": pop, "image": "imgs/a.jpg", "geneCount": "100"}
+		for k, v := range extra {
+			p[k] = v
+		}
+		return p
+	}
+
+	// Output-only flags and the seed don't split a group
+	runs := []*runData{
+		testRun("r1", params("50", map[string]string{"seed": "1", "http": "", "snapshot": "improve"})),
+		testRun("r2", params("50", map[string]string{"seed": "2", "http": ":8080", "snapshot": "all"})),
+		testRun("r3", params("100", map[string]string{"seed": "1", "runDir": "other", "fps": "12"})),
+		testRun("old", nil),
+	}
+	assignGroups(runs)
+	for i, exp := range []string{"popSize=50", "popSize=50", "popSize=100", "old"} {
+		if runs[i].Group != exp {
+			t.Errorf("Run %s is in group %q, expected %q", runs[i].Name, runs[i].Group, exp)
+		}
+	}
+
+	// Images are named by their base name, and identical runs are all one group
+	runs = []*runData{
+		testRun("r1", map[string]string{"image": "imgs/a.jpg", "seed": "1"}),
+		testRun("r2", map[string]string{"image": "imgs/b.jpg", "seed": "1"}),
+	}
+	assignGroups(runs)
+	if runs[0].Group != "image=a.jpg" || runs[1].Group != "image=b.jpg" {
+		t.Errorf("Bad image groups %q and %q", runs[0].Group, runs[1].Group)
+	}
+	runs = runs[:1]
+	assignGroups(runs)
+	if runs[0].Group != "all" {
+		t.Errorf("A single configuration is in group %q", runs[0].Group)
+	}
+
+	// Every output-only flag is ignored
+	for _, name := range outputFlags {
+		if !groupIgnore[name] {
+			t.Errorf("Output flag %s isn't ignored when grouping", name)
+		}
+	}
+}
+
+func TestGroupCurve(t *testing.T) {
+	// The short run keeps its final best after it ends
+	runs := []*runData{
+		testRun("a", nil, 10, 8, 6, 4, 2),
+		testRun("b", nil, 12, 10, 8),
+	}
+	// Generation 5 is the end, so the points are at 0, 2.5 and 5. Nothing
+	// has been seen at 0, so that point is dropped.
+	curve := groupCurve(runs, "gen", 3)
+	if len(curve) != 2 {
+		t.Fatalf("Expected 2 points, got %d", len(curve))
+	}
+	exp := []curvePoint{
+		{X: 2.5, N: 2, Mean: 9, Median: 9},
+		{X: 5, N: 2, Mean: 5, Median: 5},
+	}
+	for i, e := range exp {
+		p := curve[i]
+		if p.X != e.X || p.N != e.N || p.Mean != e.Mean || p.Median != e.Median {
+			t.Errorf("Point %d is %+v, expected %+v", i, p, e)
+		}
+		if p.Lo > p.Mean || p.Hi < p.Mean {
+			t.Errorf("Point %d has a band %f..%f around %f", i, p.Lo, p.Hi, p.Mean)
+		}
+	}
+
+	// The evals axis uses the evaluation count
+	curve = groupCurve(runs[:1], "evals", 2)
+	if last := curve[len(curve)-1]; last.X != 50 || last.Mean != 2 {
+		t.Errorf("Bad last evals point %+v", last)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// controlCmd is a single run time command
+type controlCmd struct {
+	Name  string  // pause, resume, stop, checkpoint, inject or set
+	Param string  // For set: the parameter name
+	Value float64 // For set: the new value
+}
+
+// String describes the command for the run log
+func (cmd controlCmd) String() string {
+	if cmd.Name == "set" {
+		return fmt.Sprintf("set %s=%v", cmd.Param, cmd.Value)
+	}
+	return cmd.Name
+}
+
+// controller queues run time commands (received over HTTP) for the main
+// loop, which applies them at the start of a generation. While the run is
+// paused the main loop blocks in Next, but still handles commands.
+type controller struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	paused  bool
+	pending []controlCmd
+}
+
+func newController() *controller {
+	c := &controller{}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// queue adds a command and wakes up the main loop if it is waiting
+func (c *controller) queue(cmd controlCmd) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch cmd.Name {
+	case "pause":
+		c.paused = true
+	case "resume", "stop":
+		c.paused = false
+	}
+	c.pending = append(c.pending, cmd)
+	c.cond.Broadcast()
+}
+
+// Next returns the queued commands. If the run is paused and there are no
+// commands, Next blocks until there are.
+func (c *controller) Next() []controlCmd {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for c.paused && len(c.pending) < 1 {
+		c.cond.Wait()
+	}
+
+	cmds := c.pending
+	c.pending = nil
+	return cmds
+}
+
+// Paused is true if the run should wait for more commands
+func (c *controller) Paused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused
+}
+
+// validParam checks a new value for a parameter that can be changed while a
+// run is in progress. These match the command line checks.
+func validParam(name string, val float64) error {
+	switch name {
+	case "mutationRate", "crossoverRate":
+		if val <= 0.0 || val >= 1.0 {
+			return errors.New(name + " must be between 0 and 1")
+		}
+	case "popSize":
+		if val < 10 || val != float64(int(val)) {
+			return errors.New("popSize must be an integer >= 10")
+		}
+	case "stallLimit":
+		if val < 1 || val != float64(int(val)) {
+			return errors.New("stallLimit must be an integer >= 1")
+		}
+	default:
+		return errors.New("Unknown parameter " + name)
+	}
+	return nil
+}
+
+// Handle adds the control endpoints to the mux. Commands must be POSTed:
+//
+//	/control/pause
+//	/control/resume
+//	/control/checkpoint
+//	/control/inject
+//	/control/set?mutationRate=0.2&popSize=500
+func (c *controller) Handle(mux *http.ServeMux) {
+	simple := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "Use POST", http.StatusMethodNotAllowed)
+				return
+			}
+			cmd := controlCmd{Name: name}
+			c.queue(cmd)
+			controlReply(w, []controlCmd{cmd})
+		}
+	}
+
+	mux.HandleFunc("/control/pause", simple("pause"))
+	mux.HandleFunc("/control/resume", simple("resume"))
+	mux.HandleFunc("/control/checkpoint", simple("checkpoint"))
+	mux.HandleFunc("/control/inject", simple("inject"))
+
+	mux.HandleFunc("/control/set", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Validate everything before we queue anything
+		var cmds []controlCmd
+		for name, vals := range r.Form {
+			val, err := strconv.ParseFloat(vals[len(vals)-1], 64)
+			if err == nil {
+				err = validParam(name, val)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			cmds = append(cmds, controlCmd{Name: "set", Param: name, Value: val})
+		}
+		if len(cmds) < 1 {
+			http.Error(w, "No parameters given", http.StatusBadRequest)
+			return
+		}
+
+		for _, cmd := range cmds {
+			c.queue(cmd)
+		}
+		controlReply(w, cmds)
+	})
+}
+
+// controlReply acknowledges queued commands
+func controlReply(w http.ResponseWriter, cmds []controlCmd) {
+	queued := make([]string, 0, len(cmds))
+	for _, cmd := range cmds {
+		queued = append(queued, cmd.String())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"queued": queued})
+}
+
+// startControlSocket serves the mux on a local Unix socket, e.g.
+// curl --unix-socket evo.sock -X POST http://evoimage/control/pause
+func startControlSocket(socketName string, mux *http.ServeMux) error {
+	os.Remove(socketName) // A stale socket from a previous run
+	listener, err := net.Listen("unix", socketName)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Serving run control on unix socket %s\n", socketName)
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			log.Printf("Control socket stopped: %v\n", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/CraigKelly/evoimage/evo"
+	"golang.org/x/image/draw"
+)
+
+// Dashboard defaults
+const (
+	dashEliteCount   = 12   // Number of elite thumbnails
+	dashThumbSize    = 96   // Largest dimension of a thumbnail
+	dashMaxHistory   = 4000 // We thin the fitness history past this many points
+	dashClientBuffer = 64   // Events buffered per client before we drop them
+)
+
+// dashPoint is a single point on the fitness chart
+type dashPoint struct {
+	Gen   int     `json:"gen"`
+	Best  float64 `json:"best"`
+	Avg   float64 `json:"avg"`
+	Worst float64 `json:"worst"`
+}
+
+// dashState is the current state of the run, including adaptive parameters
+type dashState struct {
+	RunID      string  `json:"runId"`
+	Gen        int     `json:"gen"`
+	Best       float64 `json:"best"`
+	Avg        float64 `json:"avg"`
+	Worst      float64 `json:"worst"`
+	PopSize    int     `json:"popSize"`
+	TournSize  int     `json:"tournSize"`
+	MutRate    float64 `json:"mutRate"`
+	StallCount int     `json:"stallCount"`
+	Evals      uint64  `json:"evals"`
+	MeanDist   float64 `json:"meanDist"`
+	Unique     int     `json:"unique"`
+}
+
+// dashboard serves a live view of a run over HTTP. It observes every
+// generation and pushes an event to every browser connected
+// to /events (Server-Sent Events). Everything is served from the binary, so
+// no network access is needed.
+type dashboard struct {
+	mu      sync.Mutex
+	target  image.Image
+	best    image.Image
+	elites  []image.Image
+	history []dashPoint
+	state   dashState
+	clients map[chan []byte]bool
+}
+
+// newDashboard creates a dashboard for the given target
+func newDashboard(runID string, target *evo.ImageTarget) *dashboard {
+	return &dashboard{
+		target:  target.Image(),
+		state:   dashState{RunID: runID},
+		clients: make(map[chan []byte]bool),
+	}
+}
+
+// Handle adds the dashboard handlers to the mux
+func (d *dashboard) Handle(mux *http.ServeMux) {
+	mux.HandleFunc("/", d.serveIndex)
+	mux.HandleFunc("/state", d.serveState)
+	mux.HandleFunc("/events", d.serveEvents)
+	mux.HandleFunc("/target.png", d.serveImage)
+	mux.HandleFunc("/best.png", d.serveImage)
+	mux.HandleFunc("/elite/", d.serveImage)
+}
+
+// Observe records a generation
+func (d *dashboard) Observe(gen *evo.Generation) error {
+	pop := gen.Population
+	elites := make([]image.Image, 0, dashEliteCount)
+	for i := 0; i < dashEliteCount && i < len(pop); i++ {
+		elites = append(elites, pop[i].Image())
+	}
+
+	state := dashState{
+		Gen:        gen.Gen,
+		Best:       gen.Best,
+		Avg:        gen.Avg,
+		Worst:      gen.Worst,
+		PopSize:    gen.PopSize,
+		TournSize:  gen.TournSize,
+		MutRate:    gen.MutRate,
+		StallCount: gen.StallCount,
+		Evals:      gen.Evals,
+		MeanDist:   gen.Diversity.MeanDist,
+		Unique:     gen.Diversity.Unique,
+	}
+	pt := dashPoint{Gen: state.Gen, Best: state.Best, Avg: state.Avg, Worst: state.Worst}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	state.RunID = d.state.RunID
+	d.state = state
+	d.best = pop[0].Image()
+	d.elites = elites
+
+	d.history = append(d.history, pt)
+	if len(d.history) > dashMaxHistory {
+		// Drop every other point, but always keep the first and last
+		thin := d.history[:1]
+		for i := 2; i < len(d.history)-1; i += 2 {
+			thin = append(thin, d.history[i])
+		}
+		d.history = append(thin, pt)
+	}
+
+	d.broadcast("gen", map[string]interface{}{"point": pt, "state": d.state})
+	return nil
+}
+
+// broadcast sends an event to every client: caller must hold the lock. Slow
+// clients miss events rather than slowing down the run.
+func (d *dashboard) broadcast(event string, data interface{}) {
+	msg, err := sseMessage(event, data)
+	if err != nil {
+		log.Printf("Dashboard event error: %v\n", err)
+		return
+	}
+	for client := range d.clients {
+		select {
+		case client <- msg:
+		default:
+		}
+	}
+}
+
+// sseMessage formats a single Server-Sent Event with a JSON payload
+func sseMessage(event string, data interface{}) ([]byte, error) {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("event: %s\ndata: %s\n\n", event, js)), nil
+}
+
+func (d *dashboard) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	client := make(chan []byte, dashClientBuffer)
+
+	// Register and grab the full history so the chart starts complete
+	d.mu.Lock()
+	d.clients[client] = true
+	first, err := sseMessage("history", map[string]interface{}{"points": d.history, "state": d.state})
+	d.mu.Unlock()
+
+	defer func() {
+		d.mu.Lock()
+		delete(d.clients, client)
+		d.mu.Unlock()
+	}()
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Write(first)
+	flusher.Flush()
+
+	done := r.Context().Done()
+	for {
+		select {
+		case <-done:
+			return
+		case msg := <-client:
+			if _, err := w.Write(msg); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func (d *dashboard) serveState(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	state := d.state
+	d.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
+
+func (d *dashboard) serveImage(w http.ResponseWriter, r *http.Request) {
+	var img image.Image
+	thumb := false
+
+	d.mu.Lock()
+	switch {
+	case r.URL.Path == "/target.png":
+		img = d.target
+	case r.URL.Path == "/best.png":
+		img = d.best
+	case strings.HasPrefix(r.URL.Path, "/elite/"):
+		idx, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/elite/"), ".png"))
+		if err == nil && idx >= 0 && idx < len(d.elites) {
+			img = d.elites[idx]
+		}
+		thumb = true
+	}
+	d.mu.Unlock()
+
+	if img == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if thumb {
+		img = thumbnail(img, dashThumbSize)
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-cache")
+	png.Encode(w, img)
+}
+
+// thumbnail scales an image so that its largest dimension is size
+func thumbnail(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := size, size
+	if b.Dx() > b.Dy() {
+		h = (b.Dy() * size) / b.Dx()
+	} else {
+		w = (b.Dx() * size) / b.Dy()
+	}
+	if w < 1 || h < 1 {
+		return img
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.ApproxBiLinear.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+func (d *dashboard) serveIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(dashboardHTML))
+}
+
+// startHTTP starts serving the mux on addr in the background. We listen
+// before returning so that a bad address fails immediately.
+func startHTTP(addr string, mux *http.ServeMux) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Serving dashboard on http://%s/\n", listener.Addr())
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			log.Printf("HTTP server stopped: %v\n", err)
+		}
+	}()
+	return nil
+}
+
+// dashboardHTML is the entire dashboard page: no external assets
+const dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>evoimage</title>
+<style>
+body { font-family: sans-serif; margin: 1em; background: #fafafa; color: #222; }
+h1 { font-size: 1.3em; }
+.row { display: flex; flex-wrap: wrap; gap: 1em; align-items: flex-start; }
+.panel { background: #fff; border: 1px solid #ddd; padding: 0.5em; }
+.panel h2 { font-size: 1em; margin: 0 0 0.5em 0; }
+img.full { image-rendering: pixelated; max-width: 384px; }
+table { border-collapse: collapse; }
+td { padding: 2px 8px; }
+td.num { text-align: right; font-family: monospace; }
+#elites img { margin: 2px; border: 1px solid #ccc; }
+.best { color: #1a7f37; } .avg { color: #0969da; } .worst { color: #cf222e; }
+</style>
+</head>
+<body>
+<h1>evoimage <span id="runId"></span></h1>
+<div class="row">
+  <div class="panel"><h2>Target</h2><img class="full" src="/target.png"></div>
+  <div class="panel"><h2>Current Best</h2><img class="full" id="best" src="/best.png"></div>
+  <div class="panel">
+    <h2>Run</h2>
+    <table id="params"></table>
+  </div>
+</div>
+<div class="row" style="margin-top: 1em">
+  <div class="panel">
+    <h2>Fitness: <span class="best">best</span>, <span class="avg">average</span>, <span class="worst">worst</span></h2>
+    <canvas id="chart" width="800" height="300"></canvas>
+  </div>
+</div>
+<div class="row" style="margin-top: 1em">
+  <div class="panel"><h2>Elites</h2><div id="elites"></div></div>
+</div>
+<script>
+"use strict";
+var points = [];
+var eliteCount = 12; // dashEliteCount
+
+var paramNames = [
+  ["gen", "Generation", 0], ["best", "Best", 4], ["avg", "Average", 4], ["worst", "Worst", 4],
+  ["popSize", "Population size", 0], ["tournSize", "Tournament size", 0],
+  ["mutRate", "Mutation rate", 5], ["stallCount", "Stall count", 0],
+  ["evals", "Evaluations", 0], ["meanDist", "Mean distance", 4], ["unique", "Unique genomes", 0]
+];
+
+function showState(state) {
+  document.getElementById("runId").textContent = state.runId || "";
+  var rows = "";
+  paramNames.forEach(function(p) {
+    var v = state[p[0]];
+    if (v === undefined) { return; }
+    rows += "<tr><td>" + p[1] + "</td><td class='num'>" + Number(v).toFixed(p[2]) + "</td></tr>";
+  });
+  document.getElementById("params").innerHTML = rows;
+
+  var stamp = "?gen=" + state.gen;
+  document.getElementById("best").src = "/best.png" + stamp;
+  var elites = document.getElementById("elites");
+  if (elites.children.length === 0) {
+    for (var i = 0; i < eliteCount; i++) {
+      var img = document.createElement("img");
+      img.title = "Rank " + (i + 1);
+      elites.appendChild(img);
+    }
+  }
+  for (var j = 0; j < elites.children.length; j++) {
+    elites.children[j].src = "/elite/" + j + ".png" + stamp;
+  }
+}
+
+function drawChart() {
+  var canvas = document.getElementById("chart");
+  var ctx = canvas.getContext("2d");
+  var w = canvas.width, h = canvas.height, pad = 40;
+  ctx.clearRect(0, 0, w, h);
+  if (points.length < 1) { return; }
+
+  var maxGen = Math.max(1, points[points.length - 1].gen);
+  var maxFit = 0;
+  points.forEach(function(p) { maxFit = Math.max(maxFit, p.worst); });
+  maxFit = Math.max(1, Math.ceil(maxFit / 5) * 5);
+
+  function x(gen) { return pad + (gen / maxGen) * (w - 2 * pad); }
+  function y(fit) { return h - pad - (fit / maxFit) * (h - 2 * pad); }
+
+  ctx.strokeStyle = "#999";
+  ctx.fillStyle = "#444";
+  ctx.font = "11px sans-serif";
+  ctx.beginPath();
+  ctx.moveTo(pad, pad); ctx.lineTo(pad, h - pad); ctx.lineTo(w - pad, h - pad);
+  ctx.stroke();
+  for (var t = 0; t <= 5; t++) {
+    var fv = (maxFit * t) / 5;
+    ctx.fillText(fv.toFixed(0), 5, y(fv) + 4);
+    var gv = Math.round((maxGen * t) / 5);
+    ctx.fillText(String(gv), x(gv) - 10, h - pad + 15);
+  }
+
+  [["worst", "#cf222e"], ["avg", "#0969da"], ["best", "#1a7f37"]].forEach(function(series) {
+    ctx.strokeStyle = series[1];
+    ctx.beginPath();
+    points.forEach(function(p, idx) {
+      if (idx === 0) { ctx.moveTo(x(p.gen), y(p[series[0]])); }
+      else { ctx.lineTo(x(p.gen), y(p[series[0]])); }
+    });
+    ctx.stroke();
+  });
+}
+
+var source = new EventSource("/events");
+source.addEventListener("history", function(e) {
+  var msg = JSON.parse(e.data);
+  points = msg.points || [];
+  if (msg.state && msg.state.gen !== undefined && points.length > 0) { showState(msg.state); }
+  drawChart();
+});
+source.addEventListener("gen", function(e) {
+  var msg = JSON.parse(e.data);
+  points.push(msg.point);
+  showState(msg.state);
+  drawChart();
+});
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,137 @@
+package evo
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"math/rand"
+	"runtime"
+)
+
+// The benchmarks of the GA's hot operations live here (rather than in a
+// _test file) so that both go test and the evoimage bench command run the
+// same code. The callers do the timing: see benchmark_test.go.
+
+// BenchSizes and BenchGenes are the default target sizes (in pixels, the
+// targets are square) and gene counts to benchmark
+var (
+	BenchSizes = []int{64, 128, 256, 512, 1024}
+	BenchGenes = []int{10, 100, 1000}
+)
+
+// BenchOps are the operations a Benchmark can run
+var BenchOps = []string{"fitness", "evalpop", "mutation", "crossover"}
+
+// Benchmark is one operation set up on a synthetic target, ready to run N
+// times under a timer
+type Benchmark struct {
+	Op         string
+	Size       int
+	Genes      int
+	Cores      int
+	EvalsPerOp int // Fitness evaluations per op (0 if the op doesn't evaluate)
+	op         func()
+}
+
+// NewBenchmark sets up an op from BenchOps. The population size and cores
+// only apply to evalpop (cores < 1 uses all of them).
+func NewBenchmark(op string, size int, geneCount int, popSize int, cores int) (*Benchmark, error) {
+	if size < 1 || geneCount < 1 || popSize < 1 {
+		return nil, fmt.Errorf("Invalid benchmark size %d, genes %d or population %d", size, geneCount, popSize)
+	}
+	bm := &Benchmark{Op: op, Size: size, Genes: geneCount, Cores: 1}
+	target := newBenchTarget(size)
+
+	switch op {
+	case "fitness":
+		ind := benchIndividual(target, geneCount)
+		bm.EvalsPerOp = 1
+		bm.op = func() {
+			ind.needImage = true
+			ind.Fitness()
+		}
+	case "evalpop":
+		pop := make(Population, popSize)
+		for i := range pop {
+			pop[i] = benchIndividual(target, geneCount)
+		}
+		bm.Cores = cores
+		if bm.Cores < 1 {
+			bm.Cores = runtime.NumCPU()
+		}
+		bm.EvalsPerOp = popSize
+		ctx := context.Background()
+		bm.op = func() {
+			for _, ind := range pop {
+				ind.needImage = true
+			}
+			evalPop(ctx, pop, bm.Cores)
+		}
+	case "mutation":
+		ind := benchIndividual(target, geneCount)
+		rate := DefaultOptions().MutationRate
+		bm.op = func() {
+			Mutation(ind, rate)
+		}
+	case "crossover":
+		p1 := benchIndividual(target, geneCount)
+		p2 := benchIndividual(target, geneCount)
+		rate := DefaultOptions().CrossoverRate
+		bm.op = func() {
+			Crossover(p1, p2, rate)
+		}
+	default:
+		return nil, fmt.Errorf("Unknown benchmark op %s - must be fitness, evalpop, mutation or crossover", op)
+	}
+	return bm, nil
+}
+
+// Run runs the op n times
+func (bm *Benchmark) Run(n int) {
+	for i := 0; i < n; i++ {
+		bm.op()
+	}
+}
+
+// newSyntheticTarget creates a target in memory with pixels from fill (for
+// benchmarks and tests)
+func newSyntheticTarget(name string, w, h int, fill func(x, y int) color.NRGBA) *ImageTarget {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, fill(x, y))
+		}
+	}
+	return NewImageTargetFromImage(name, img)
+}
+
+// newBenchTarget creates a square synthetic target: a color gradient with
+// some seeded noise, so fitness has real work to do
+func newBenchTarget(size int) *ImageTarget {
+	r := rand.New(rand.NewSource(int64(size)))
+	target := newSyntheticTarget("bench", size, size, func(x, y int) color.NRGBA {
+		return color.NRGBA{
+			R: uint8(x * 255 / size),
+			G: uint8(y * 255 / size),
+			B: uint8(r.Intn(256)),
+			A: 255,
+		}
+	})
+
+	// Like NewEngine: calc the lazy stats now so that evalPop's goroutines
+	// don't race to do it
+	target.ImageMode()
+	return target
+}
+
+// benchIndividual creates a random individual with visible genes (new genes
+// start transparent, which isn't what an evolved genome looks like)
+func benchIndividual(target *ImageTarget, geneCount int) *Individual {
+	ind := NewIndividual(target, geneCount)
+	ind.RandInit()
+	for _, g := range ind.genes {
+		g.destColor.A = uint8(rand.Intn(256))
+	}
+	return ind
+}
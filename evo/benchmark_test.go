@@ -0,0 +1,57 @@
+package evo
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+)
+
+// Run with something like:
+//
+//	go test ./evo -run XXX -bench . -benchmem
+//	go test ./evo -run XXX -bench 'Fitness/size=256/'
+//
+// The benchmarks themselves are in bench.go, which the evoimage bench command
+// uses too.
+
+// benchOp times a Benchmark (set up only when the sub-benchmark runs)
+func benchOp(op string, size int, geneCount int, popSize int, cores int) func(b *testing.B) {
+	return func(b *testing.B) {
+		bm, err := NewBenchmark(op, size, geneCount, popSize, cores)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		bm.Run(b.N)
+	}
+}
+
+func BenchmarkFitness(b *testing.B) {
+	for _, size := range BenchSizes {
+		for _, genes := range BenchGenes {
+			b.Run(fmt.Sprintf("size=%d/genes=%d", size, genes), benchOp("fitness", size, genes, 1, 1))
+		}
+	}
+}
+
+func BenchmarkEvalPop(b *testing.B) {
+	cores := runtime.NumCPU()
+	for _, size := range BenchSizes {
+		for _, genes := range BenchGenes {
+			b.Run(fmt.Sprintf("size=%d/genes=%d/pop=50/cores=%d", size, genes, cores), benchOp("evalpop", size, genes, 50, cores))
+		}
+	}
+}
+
+func BenchmarkMutation(b *testing.B) {
+	for _, genes := range BenchGenes {
+		b.Run(fmt.Sprintf("genes=%d", genes), benchOp("mutation", 256, genes, 1, 1))
+	}
+}
+
+func BenchmarkCrossover(b *testing.B) {
+	for _, genes := range BenchGenes {
+		b.Run(fmt.Sprintf("genes=%d", genes), benchOp("crossover", 256, genes, 1, 1))
+	}
+}
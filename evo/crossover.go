@@ -0,0 +1,43 @@
+package evo
+
+import "math/rand"
+
+// Crossover copies the two parents to children and performs crossover at the
+// given rate. Evolved palettes are crossed over the same way as the genes,
+// and then each gene switches to the child's palette color closest to the
+// color it had in its parent (so that crossover doesn't recolor genes).
+func Crossover(parent1 *Individual, parent2 *Individual, rate float64) (*Individual, *Individual) {
+	child1 := NewIndividual(parent1.target, len(parent1.genes))
+	child2 := NewIndividual(parent2.target, len(parent2.genes))
+	child1.palette = copyPalette(parent1.palette)
+	child2.palette = copyPalette(parent2.palette)
+	if parent1.target.evolvePalette && child1.palette != nil && len(child1.palette) == len(child2.palette) {
+		for idx := range child1.palette {
+			if rand.Float64() <= rate {
+				child1.palette[idx], child2.palette[idx] = child2.palette[idx], child1.palette[idx]
+			}
+		}
+	}
+
+	for idx, g1 := range parent1.genes {
+		g2 := parent2.genes[idx]
+		if rand.Float64() <= rate {
+			g1, g2 = g2, g1
+		}
+
+		child1.genes[idx] = g1.Copy()
+		child2.genes[idx] = g2.Copy()
+	}
+
+	for _, child := range []*Individual{child1, child2} {
+		if child.palette == nil {
+			continue
+		}
+		if child.target.evolvePalette {
+			child.remapPalette()
+		}
+		child.applyPalette()
+	}
+
+	return child1, child2
+}
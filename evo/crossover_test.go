@@ -0,0 +1,67 @@
+package evo
+
+import (
+	"math/rand"
+	"testing"
+	"testing/quick"
+)
+
+func TestCrossoverConservation(t *testing.T) {
+	// Each position of the children holds the parents' genes at that
+	// position (in some order), as deep copies, and the parents don't change
+	prop := func(tg testGenome, seed int64, rate float64) bool {
+		p1 := tg.ind
+		p2 := randIndividual(rand.New(rand.NewSource(seed)), len(p1.genes))
+		before1, before2 := copyGenes(p1), copyGenes(p2)
+
+		c1, c2 := Crossover(p1, p2, rate)
+		if len(c1.genes) != len(p1.genes) || len(c2.genes) != len(p2.genes) {
+			return false
+		}
+
+		for i := range p1.genes {
+			g1, g2 := p1.genes[i], p2.genes[i]
+			if !geneEqual(g1, before1[i]) || !geneEqual(g2, before2[i]) {
+				return false
+			}
+
+			straight := geneEqual(c1.genes[i], g1) && geneEqual(c2.genes[i], g2)
+			swapped := geneEqual(c1.genes[i], g2) && geneEqual(c2.genes[i], g1)
+			if !straight && !swapped {
+				return false
+			}
+
+			for _, c := range []*Gene{c1.genes[i], c2.genes[i]} {
+				if geneShared(c, g1) || geneShared(c, g2) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	if err := quick.Check(prop, quickConfig()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCrossoverRates(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	p1, p2 := randIndividual(r, 20), randIndividual(r, 20)
+
+	// Rate 0 copies the parents and rate 1 swaps every gene
+	for _, tc := range []struct {
+		rate    float64
+		swapped bool
+	}{{0.0, false}, {1.0, true}} {
+		c1, c2 := Crossover(p1, p2, tc.rate)
+		for i := range p1.genes {
+			exp1, exp2 := p1.genes[i], p2.genes[i]
+			if tc.swapped {
+				exp1, exp2 = exp2, exp1
+			}
+			if !geneEqual(c1.genes[i], exp1) || !geneEqual(c2.genes[i], exp2) {
+				t.Errorf("Rate %v: gene %d in the wrong child", tc.rate, i)
+			}
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package evo
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"image"
+	"math"
+)
+
+// DistanceFunc is a measure of how different two individuals are. All our
+// distances are 0 for clones and at most 1.
+type DistanceFunc func(*Individual, *Individual) float64
+
+// NewDistanceFunc returns the distance function with the given name
+func NewDistanceFunc(name string) (DistanceFunc, bool) {
+	switch name {
+	case "genome":
+		return GenomeDistance, true
+	case "vertex":
+		return VertexDistance, true
+	case "color":
+		return ColorDistance, true
+	case "pixel":
+		return ImageDistance, true
+	}
+	return nil, false
+}
+
+// alignedGenes calls f for every pair of genes at the same position and
+// returns the mean of the results
+func alignedGenes(ind1 *Individual, ind2 *Individual, f func(*Gene, *Gene) float64) float64 {
+	count := len(ind1.genes)
+	if len(ind2.genes) < count {
+		count = len(ind2.genes)
+	}
+	if count < 1 {
+		return 0.0
+	}
+
+	tot := float64(0.0)
+	for i := 0; i < count; i++ {
+		tot += f(ind1.genes[i], ind2.genes[i])
+	}
+	return tot / float64(count)
+}
+
+// VertexDistance is the gene-aligned mean distance between triangle
+// vertices, scaled by the image diagonal
+func VertexDistance(ind1 *Individual, ind2 *Individual) float64 {
+	b := ind1.target.imageData.Bounds()
+	diag := math.Sqrt(float64(b.Dx()*b.Dx() + b.Dy()*b.Dy()))
+
+	return alignedGenes(ind1, ind2, func(g1 *Gene, g2 *Gene) float64 {
+		vd := float64(0.0)
+		for idx, p1 := range g1.destVertices {
+			p2 := g2.destVertices[idx]
+			dx := float64(p1.X - p2.X)
+			dy := float64(p1.Y - p2.Y)
+			vd += math.Min(math.Sqrt(dx*dx+dy*dy)/diag, 1.0)
+		}
+		return vd / float64(len(g1.destVertices))
+	})
+}
+
+// ColorDistance is the gene-aligned mean Euclidean RGBA distance, scaled by
+// the largest possible RGBA distance
+func ColorDistance(ind1 *Individual, ind2 *Individual) float64 {
+	return alignedGenes(ind1, ind2, func(g1 *Gene, g2 *Gene) float64 {
+		c1, c2 := g1.destColor, g2.destColor
+		rd := float64(c1.R) - float64(c2.R)
+		gd := float64(c1.G) - float64(c2.G)
+		bd := float64(c1.B) - float64(c2.B)
+		ad := float64(c1.A) - float64(c2.A)
+		return math.Sqrt(rd*rd+gd*gd+bd*bd+ad*ad) / (255.0 * 2.0)
+	})
+}
+
+// GenomeDistance is the mean of the vertex and color distance
+func GenomeDistance(ind1 *Individual, ind2 *Individual) float64 {
+	return (VertexDistance(ind1, ind2) + ColorDistance(ind1, ind2)) / 2.0
+}
+
+// ImageDistance is the mean RGB distance between the rendered images of two
+// individuals, scaled by the maximum RGB distance. Note that this is much
+// more expensive than the genome distances, and the individuals will be
+// evaluated if they haven't been already.
+func ImageDistance(ind1 *Individual, ind2 *Individual) float64 {
+	ind1.Fitness()
+	ind2.Fitness()
+
+	img1 := ind1.imageData.(*image.NRGBA)
+	img2 := ind2.imageData.(*image.NRGBA)
+
+	tot := float64(0.0)
+	pixels := 0
+	for i := 0; i+3 < len(img1.Pix) && i+3 < len(img2.Pix); i += 4 {
+		rd := float64(img1.Pix[i]) - float64(img2.Pix[i])
+		gd := float64(img1.Pix[i+1]) - float64(img2.Pix[i+1])
+		bd := float64(img1.Pix[i+2]) - float64(img2.Pix[i+2])
+		tot += math.Sqrt(rd*rd + gd*gd + bd*bd)
+		pixels++
+	}
+	if pixels < 1 {
+		return 0.0
+	}
+
+	oneMax := math.Sqrt(255.0 * 255.0 * 3.0)
+	return tot / (float64(pixels) * oneMax)
+}
+
+// genomeHash returns a hash of the genome used to count unique genomes
+func genomeHash(ind *Individual) uint64 {
+	h := fnv.New64a()
+	buf := make([]byte, 4)
+	for _, g := range ind.genes {
+		for _, pt := range g.destVertices {
+			binary.LittleEndian.PutUint32(buf, uint32(pt.X))
+			h.Write(buf)
+			binary.LittleEndian.PutUint32(buf, uint32(pt.Y))
+			h.Write(buf)
+		}
+		h.Write([]byte{g.destColor.R, g.destColor.G, g.destColor.B, g.destColor.A})
+	}
+	return h.Sum64()
+}
@@ -0,0 +1,206 @@
+package evo
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+)
+
+// samplePop returns a random sample of sampleSize individuals, or the
+// population itself if it isn't larger than sampleSize
+func samplePop(pop Population, sampleSize int) Population {
+	if sampleSize < 1 || len(pop) <= sampleSize {
+		return pop
+	}
+
+	sample := make(Population, sampleSize)
+	for write, read := range rand.Perm(len(pop))[:sampleSize] {
+		sample[write] = pop[read]
+	}
+	return sample
+}
+
+// MeanDistance returns the mean pairwise distance of the population. If the
+// population is larger than sampleSize then a random sample of that size is
+// used instead.
+func MeanDistance(pop Population, sampleSize int, dist DistanceFunc) float64 {
+	sample := samplePop(pop, sampleSize)
+
+	tot := float64(0.0)
+	pairs := 0
+	for i := 0; i < len(sample); i++ {
+		for j := i + 1; j < len(sample); j++ {
+			tot += dist(sample[i], sample[j])
+			pairs++
+		}
+	}
+	if pairs < 1 {
+		return 0.0
+	}
+	return tot / float64(pairs)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Fitness sharing
+
+// sharedPop sorts a population by shared fitness without touching the raw
+// (cached) fitness of each individual
+type sharedPop struct {
+	pop    Population
+	shared []float64
+}
+
+func (s sharedPop) Len() int { return len(s.pop) }
+func (s sharedPop) Swap(i, j int) {
+	s.pop[i], s.pop[j] = s.pop[j], s.pop[i]
+	s.shared[i], s.shared[j] = s.shared[j], s.shared[i]
+}
+func (s sharedPop) Less(i, j int) bool { return s.shared[i] < s.shared[j] }
+
+// SharedPopulation returns a copy of the (evaluated) population sorted by
+// shared fitness. Since we minimize fitness, each raw fitness is multiplied
+// by its niche count: the sum of the triangular sharing function
+// 1 - d/radius over every individual within radius (including itself).
+// Crowded niches are penalized and selection favors lonely individuals.
+func SharedPopulation(pop Population, radius float64, dist DistanceFunc) Population {
+	niche := make([]float64, len(pop))
+	for i := range pop {
+		niche[i] += 1.0 // distance to self is 0
+		for j := i + 1; j < len(pop); j++ {
+			d := dist(pop[i], pop[j])
+			if d < radius {
+				sh := 1.0 - (d / radius)
+				niche[i] += sh
+				niche[j] += sh
+			}
+		}
+	}
+
+	sp := sharedPop{
+		pop:    make(Population, len(pop)),
+		shared: make([]float64, len(pop)),
+	}
+	copy(sp.pop, pop)
+	for i, ind := range pop {
+		sp.shared[i] = ind.Fitness() * niche[i]
+	}
+	sort.Sort(sp)
+
+	return sp.pop
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Deterministic crowding
+
+// Crowding performs a generation of deterministic crowding replacement.
+// Parents are randomly paired, each pair produces two children, and each
+// child competes only against the parent it is closest to. The returned
+// population is the same size as pop and has already been evaluated. If the
+// context is done while the children are evaluated, we return its error.
+func Crowding(ctx context.Context, pop Population, crossRate float64, mutRate float64, cores int, dist DistanceFunc) (Population, error) {
+	order := rand.Perm(len(pop))
+	children := make(Population, 0, len(pop))
+	for i := 0; i+1 < len(order); i += 2 {
+		child1, child2 := Crossover(pop[order[i]], pop[order[i+1]], crossRate)
+		children = append(children, Mutation(child1, mutRate))
+		children = append(children, Mutation(child2, mutRate))
+	}
+
+	if err := evalPop(ctx, children, cores); err != nil {
+		return nil, err
+	}
+
+	better := func(child *Individual, parent *Individual) *Individual {
+		if child.Fitness() <= parent.Fitness() {
+			return child
+		}
+		return parent
+	}
+
+	next := make(Population, 0, len(pop))
+	for i := 0; i+1 < len(order); i += 2 {
+		p1, p2 := pop[order[i]], pop[order[i+1]]
+		c1, c2 := children[i], children[i+1]
+
+		straight := dist(p1, c1) + dist(p2, c2)
+		crossed := dist(p1, c2) + dist(p2, c1)
+		if straight <= crossed {
+			next = append(next, better(c1, p1), better(c2, p2))
+		} else {
+			next = append(next, better(c2, p1), better(c1, p2))
+		}
+	}
+
+	// Odd man out just survives
+	if len(order)%2 == 1 {
+		next = append(next, pop[order[len(order)-1]])
+	}
+
+	return next, nil
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Random immigrants
+
+// Immigrants replaces the last rate fraction of the population with new
+// random individuals and returns the number replaced. The front of the
+// population (where we keep our elites) is never touched.
+func Immigrants(pop Population, rate float64) int {
+	count := int(float64(len(pop)) * rate)
+	if count < 1 {
+		return 0
+	}
+
+	for i := len(pop) - count; i < len(pop); i++ {
+		ind := NewIndividual(pop[i].target, len(pop[i].genes))
+		ind.RandInit()
+		pop[i] = ind
+	}
+
+	return count
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Diversity statistics
+
+// DiversityStats summarizes how converged a (sorted) population is
+type DiversityStats struct {
+	MeanDist      float64 // Mean pairwise distance
+	BestDist      float64 // Mean distance to the best individual
+	PixelBestDist float64 // Mean rendered image distance to the best individual
+	Unique        int     // Number of distinct genomes in the whole population
+}
+
+// NewDiversityStats calculates diversity for a sorted and evaluated
+// population using the given distance (nil is GenomeDistance), which should
+// be the one used for sharing and crowding so that the immigrant threshold
+// measures the same thing. Distances are calculated over a random sample of
+// at most sampleSize individuals, but unique genomes are counted over
+// everyone.
+func NewDiversityStats(pop Population, sampleSize int, dist DistanceFunc) DiversityStats {
+	if dist == nil {
+		dist = GenomeDistance
+	}
+	stats := DiversityStats{
+		MeanDist: MeanDistance(pop, sampleSize, dist),
+	}
+
+	best := pop[0]
+	sample := samplePop(pop[1:], sampleSize)
+	for _, ind := range sample {
+		stats.BestDist += dist(best, ind)
+		stats.PixelBestDist += ImageDistance(best, ind)
+	}
+	if len(sample) > 0 {
+		stats.BestDist /= float64(len(sample))
+		stats.PixelBestDist /= float64(len(sample))
+	}
+
+	seen := make(map[uint64]bool)
+	for _, ind := range pop {
+		seen[genomeHash(ind)] = true
+	}
+	stats.Unique = len(seen)
+
+	return stats
+}
@@ -0,0 +1,469 @@
+// Package evo evolves a set of translucent triangles to reproduce a target
+// image. Most users only need NewImageTarget, NewEngine and Engine.Run (or
+// Engine.Step to drive the generations themselves). Randomness comes from
+// math/rand, so seed it for repeatable runs.
+package evo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Options configure an Engine. Start from DefaultOptions.
+type Options struct {
+	GeneCount          int          // Genes (triangles) in an individual
+	PopSize            int          // Base population size (it grows when we stall)
+	MutationRate       float64      // Base mutation rate (it grows when we stall)
+	CrossoverRate      float64      // Uniform crossover rate
+	SharingRadius      float64      // Fitness sharing niche radius (0 disables sharing)
+	Crowding           bool         // Deterministic crowding replacement instead of elitism
+	ImmigrantThreshold float64      // Inject random immigrants when diversity falls below this (0 disables)
+	ImmigrantRate      float64      // Fraction of the population replaced by immigrants
+	DiversitySample    int          // Max individuals sampled when measuring diversity
+	Distance           DistanceFunc // Used for sharing, crowding and diversity (nil is GenomeDistance)
+	Cores              int          // Cores used for evaluation (0 uses all of them)
+
+	// Stopping criteria: a run stops as soon as any of them is met
+	StallLimit     int           // Stop after this many generations without improvement
+	MaxGenerations int           // Stop after this many generations
+	MaxTime        time.Duration // Stop after this much wall clock time since NewEngine (0 for no limit)
+	MaxEvals       uint64        // Stop after this many fitness evaluations (0 for no limit)
+	TargetFitness  float64       // Stop when the best fitness is below this
+	ImproveWindow  int           // Stop if the best fitness improves by less than
+	ImproveMin     float64       // ImproveMin (relative) in ImproveWindow generations (0 disables)
+}
+
+// DefaultOptions returns the options used by the evoimage command
+func DefaultOptions() Options {
+	return Options{
+		GeneCount:       100,
+		PopSize:         300,
+		MutationRate:    0.11,
+		CrossoverRate:   0.60,
+		ImmigrantRate:   0.10,
+		DiversitySample: 50,
+		StallLimit:      100,
+		MaxGenerations:  100000,
+		TargetFitness:   0.5,
+		ImproveMin:      0.001,
+	}
+}
+
+// Validate checks that the options make sense
+func (o Options) Validate() error {
+	switch {
+	case o.MutationRate <= 0.0 || o.MutationRate >= 1.0:
+		return errors.New("Invalid mutation rate - must be between 0 and 1")
+	case o.CrossoverRate <= 0.0 || o.CrossoverRate >= 1.0:
+		return errors.New("Invalid crossover rate - must be between 0 and 1")
+	case o.PopSize < 10:
+		return errors.New("Invalid population size - must be at least 10")
+	case o.GeneCount < 2:
+		return errors.New("Gene Count must be >= 2")
+	case o.SharingRadius < 0.0 || o.SharingRadius > 1.0:
+		return errors.New("Invalid sharing radius - must be between 0 and 1")
+	case o.ImmigrantThreshold < 0.0 || o.ImmigrantThreshold > 1.0:
+		return errors.New("Invalid immigrant threshold - must be between 0 and 1")
+	case o.ImmigrantRate <= 0.0 || o.ImmigrantRate > 0.5:
+		return errors.New("Invalid immigrant rate - must be greater than 0 and at most 0.5")
+	case o.StallLimit < 1:
+		return errors.New("Stall limit must be >= 1")
+	case o.MaxGenerations < 1:
+		return errors.New("Max generations must be >= 1")
+	case o.MaxTime < 0:
+		return errors.New("Max time must be >= 0")
+	case o.TargetFitness < 0.0 || o.TargetFitness > 100.0:
+		return errors.New("Invalid target fitness - must be between 0 and 100")
+	case o.ImproveWindow < 0:
+		return errors.New("Improvement window must be >= 0")
+	case o.ImproveMin < 0.0 || o.ImproveMin >= 1.0:
+		return errors.New("Invalid minimum improvement - must be at least 0 and less than 1")
+	case o.DiversitySample < 2:
+		return errors.New("Diversity sample must be >= 2")
+	}
+	return nil
+}
+
+// Generation summarizes a generation of a run
+type Generation struct {
+	Gen        int
+	Best       float64
+	Worst      float64
+	Avg        float64
+	PopSize    int
+	TournSize  int
+	MutRate    float64 // Adaptive mutation rate
+	StallCount int
+	Evals      uint64        // Fitness evaluations so far
+	EvalTime   time.Duration // Time spent evaluating in this generation
+	Diversity  DiversityStats
+	Genes      GeneStats // Of the best individual
+	Immigrants int       // Random immigrants added to the next generation
+
+	// The sorted and evaluated population: Population[0] is the best
+	Population Population
+}
+
+// Engine runs a genetic algorithm against a target image
+type Engine struct {
+	target *ImageTarget
+	opts   Options
+	cores  int
+
+	pop        Population // Next generation to evaluate
+	gen        int        // Number of the next generation
+	stallCount int
+	lastBest   float64
+	best       *Individual
+	observers  []Observer
+
+	start   time.Time // When the engine was created: MaxTime is from here
+	history []float64 // Best fitness of the last ImproveWindow+1 generations
+}
+
+// NewEngine creates an engine with a random initial population
+func NewEngine(target *ImageTarget, opts Options) (*Engine, error) {
+	e := &Engine{target: target, lastBest: 100.0, start: time.Now()}
+	if err := e.SetOptions(opts); err != nil {
+		return nil, err
+	}
+
+	// Calculate the target stats now, rather than racing to do it during
+	// the first evaluation
+	target.ImageMode()
+
+	e.pop = make(Population, 0, opts.PopSize)
+	for i := 0; i < opts.PopSize; i++ {
+		ind := NewIndividual(target, opts.GeneCount)
+		ind.RandInit()
+		e.pop = append(e.pop, ind)
+	}
+	return e, nil
+}
+
+// Resume replaces the population and run state (e.g. from a checkpoint).
+// lastBest is the best fitness of the generation before, so the stall count
+// carries on correctly. If it isn't known (0), the best of the restored
+// population is used.
+func (e *Engine) Resume(pop Population, generation int, stallCount int, lastBest float64) {
+	e.pop = pop
+	e.gen = generation
+	e.stallCount = stallCount
+	e.history = nil
+
+	e.lastBest = lastBest
+	if e.lastBest <= 0.0 {
+		evalPop(context.Background(), pop, e.cores)
+		e.lastBest = math.Inf(1)
+		for _, ind := range pop {
+			e.lastBest = math.Min(e.lastBest, ind.Fitness())
+		}
+	}
+}
+
+// Options returns the current options
+func (e *Engine) Options() Options {
+	return e.opts
+}
+
+// SetOptions changes the options. Changes take effect in the next Step, but
+// the gene count only applies to new individuals.
+func (e *Engine) SetOptions(opts Options) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	if opts.Distance == nil {
+		opts.Distance = GenomeDistance
+	}
+
+	e.opts = opts
+	// An explicit core count is used as is (a sweep splits its cores between
+	// jobs), but the default is at least 2
+	e.cores = opts.Cores
+	if e.cores < 1 {
+		e.cores = runtime.NumCPU()
+		if e.cores < 2 {
+			e.cores = 2
+		}
+	}
+	return nil
+}
+
+// Target is the image we are evolving towards
+func (e *Engine) Target() *ImageTarget {
+	return e.target
+}
+
+// Cores is the number of cores used for evaluation
+func (e *Engine) Cores() int {
+	return e.cores
+}
+
+// Generation is the number of the next generation Step will run
+func (e *Engine) Generation() int {
+	return e.gen
+}
+
+// StallCount is the number of generations since the best fitness improved
+func (e *Engine) StallCount() int {
+	return e.stallCount
+}
+
+// Population is the next generation: it isn't evaluated or sorted
+func (e *Engine) Population() Population {
+	return e.pop
+}
+
+// Best returns the best individual of the last generation (nil before the
+// first Step)
+func (e *Engine) Best() *Individual {
+	return e.best
+}
+
+// AddObserver registers an observer to be called every generation
+func (e *Engine) AddObserver(obs Observer) {
+	e.observers = append(e.observers, obs)
+}
+
+// Inject replaces part of the next generation with random immigrants and
+// returns how many were added
+func (e *Engine) Inject() int {
+	return Immigrants(e.pop, e.opts.ImmigrantRate)
+}
+
+// Elapsed is the wall clock time since the engine was created (which is what
+// MaxTime limits), including time spent paused or between steps
+func (e *Engine) Elapsed() time.Duration {
+	return time.Since(e.start)
+}
+
+// StopReason returns why the run should stop, or an empty string if it
+// should keep going. It is checked between generations.
+func (e *Engine) StopReason() string {
+	opts := e.opts
+	switch {
+	case e.gen >= opts.MaxGenerations:
+		return "generation limit"
+	case e.stallCount > opts.StallLimit:
+		return "stall limit"
+	case e.lastBest < opts.TargetFitness:
+		return "target fitness"
+	case opts.MaxTime > 0 && e.Elapsed() >= opts.MaxTime:
+		return "time limit"
+	case opts.MaxEvals > 0 && e.target.Evals() >= opts.MaxEvals:
+		return "evaluation limit"
+	}
+
+	if opts.ImproveWindow > 0 && len(e.history) > opts.ImproveWindow {
+		then := e.history[len(e.history)-opts.ImproveWindow-1]
+		if then <= 0.0 || (then-e.lastBest)/then < opts.ImproveMin {
+			return fmt.Sprintf("improvement below %g%% in %d generations", opts.ImproveMin*100.0, opts.ImproveWindow)
+		}
+	}
+	return ""
+}
+
+// Run steps through generations until StopReason is set, the context is done
+// (in which case the context's error is returned), or an observer fails
+func (e *Engine) Run(ctx context.Context) error {
+	for e.StopReason() == "" {
+		if _, err := e.Step(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Step evaluates the current generation, breeds the next one, and then tells
+// the observers. The first observer error stops the remaining observers and
+// is returned (the generation has still been run). If the context is done
+// before the generation is finished, the generation is abandoned (so the
+// next Step runs it again) and the context's error is returned.
+func (e *Engine) Step(ctx context.Context) (*Generation, error) {
+	opts := e.opts
+	generation := e.gen
+
+	// Image creation and evaluation across all cores
+	evalStart := time.Now()
+	if err := evalPop(ctx, e.pop, e.cores); err != nil {
+		return nil, err
+	}
+	evalTime := time.Since(evalStart)
+
+	// Now we can sort and find best/worst
+	sort.Sort(e.pop)
+	bestInd := e.pop[0]
+	best := bestInd.Fitness()
+	worst := e.pop[len(e.pop)-1].Fitness()
+	avg := e.pop.MeanFitness()
+	divStats := NewDiversityStats(e.pop, opts.DiversitySample, opts.Distance)
+	diversity := divStats.MeanDist
+
+	stallCount := e.stallCount + 1
+	if math.Abs(best-e.lastBest) >= 0.0000001 {
+		stallCount = 0
+	}
+
+	// Fitness is supposed to be minimized and is 0-100. We constrict the
+	// tournament as we get closer to the theoretical best score
+	// However, we will increase our tournament size if we have stalled
+	// Note that we also adaptively increase mutation rate and population
+	// size when we stall
+	tournSize := 0
+	if best > 33.0 {
+		tournSize = 4
+	} else if best > 4.0 {
+		tournSize = 3
+	} else {
+		tournSize = 2
+	}
+	if stallCount > 1 {
+		xts := 0
+		if stallCount < 15 {
+			xts = 1
+		} else if stallCount < 30 {
+			xts = 2
+		} else {
+			xts = 3
+		}
+		tournSize += xts
+	}
+
+	maxMutRate := 1.30 * opts.MutationRate
+	adaptMutRate := opts.MutationRate + (0.0035 * float64(stallCount))
+	if adaptMutRate > maxMutRate {
+		adaptMutRate = maxMutRate
+	}
+
+	// We add 2x stall count for a larger population. The other 2x are
+	// for the adaptive elitism below
+	adaptPopSize := opts.PopSize + (stallCount * 4)
+
+	oldPop := e.pop
+	summary := &Generation{
+		Gen:        generation,
+		Best:       best,
+		Worst:      worst,
+		Avg:        avg,
+		PopSize:    len(oldPop),
+		TournSize:  tournSize,
+		MutRate:    adaptMutRate,
+		StallCount: stallCount,
+		Diversity:  divStats,
+		Genes:      bestInd.GeneStats(),
+		Population: oldPop,
+	}
+
+	// Selection works on the shared fitness order if we are sharing
+	selPop := oldPop
+	if opts.SharingRadius > 0.0 {
+		selPop = SharedPopulation(oldPop, opts.SharingRadius, opts.Distance)
+	}
+
+	var population Population
+	if opts.Crowding {
+		// Deterministic crowding is its own replacement strategy (and
+		// never loses the best individual), so no elitism
+		evalStart := time.Now()
+		var err error
+		population, err = Crowding(ctx, oldPop, opts.CrossoverRate, adaptMutRate, e.cores, opts.Distance)
+		if err != nil {
+			return nil, err
+		}
+		evalTime += time.Since(evalStart)
+		sort.Sort(population)
+		if len(population) > adaptPopSize {
+			population = population[:adaptPopSize]
+		}
+	} else {
+		population = Population(make([]*Individual, 0, adaptPopSize+5+(stallCount/2)))
+
+		// Elitism - we keep best 5 individuals AND a shuffled/mutated copy of the best 5
+		// We also adapt to the current stall count
+		for i := 0; i < (5+stallCount) && i < len(oldPop); i++ {
+			population = append(population, oldPop[i])
+			population = append(population, Mutation(Shuffle(oldPop[i]), adaptMutRate))
+		}
+	}
+
+	// Now create rest of population with selection/crossover/mutation
+	for len(population) < adaptPopSize {
+		// Select with tournament selection
+		parent1 := Selection(selPop, tournSize)
+		parent2 := Selection(selPop, tournSize)
+
+		child1, child2 := Crossover(parent1, parent2, opts.CrossoverRate)
+
+		population = append(population, Mutation(child1, adaptMutRate))
+		population = append(population, Mutation(child2, adaptMutRate))
+	}
+
+	// Random immigrants if we have converged too far
+	if diversity < opts.ImmigrantThreshold {
+		summary.Immigrants = Immigrants(population, opts.ImmigrantRate)
+	}
+
+	// The generation is done, so we can update our state
+	e.pop = population
+	e.gen++
+	e.best = bestInd
+	e.stallCount = stallCount
+	e.lastBest = best
+	if opts.ImproveWindow > 0 {
+		e.history = append(e.history, best)
+		if len(e.history) > opts.ImproveWindow+1 {
+			e.history = e.history[len(e.history)-opts.ImproveWindow-1:]
+		}
+	}
+
+	summary.Evals = e.target.Evals()
+	summary.EvalTime = evalTime
+
+	for _, obs := range e.observers {
+		if err := obs.Observe(summary); err != nil {
+			return summary, err
+		}
+	}
+	return summary, nil
+}
+
+// evalPop evaluates the population across cores. If the context is done we
+// stop handing out work and return the context's error once the evaluations
+// in progress finish (so some of the population may not be evaluated).
+func evalPop(ctx context.Context, pop Population, cores int) error {
+	wait := sync.WaitGroup{}
+	wait.Add(cores)
+
+	work := make(chan int, 256)
+
+	for c := 0; c < cores; c++ {
+		go func() {
+			defer wait.Done()
+			for idx := range work {
+				if ctx.Err() == nil {
+					pop[idx].Fitness()
+				}
+			}
+		}()
+	}
+
+	done := ctx.Done()
+feed:
+	for i := range pop {
+		select {
+		case work <- i:
+		case <-done:
+			break feed
+		}
+	}
+	close(work)
+
+	wait.Wait()
+	return ctx.Err()
+}
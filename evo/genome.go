@@ -0,0 +1,78 @@
+package evo
+
+import (
+	"errors"
+	"image"
+	"image/color"
+)
+
+// GeneJSON is the saved form of a Gene
+type GeneJSON struct {
+	Vertices [][2]int `json:"v"`
+	Color    [4]uint8 `json:"c"`           // RGBA
+	Index    int      `json:"i,omitempty"` // Palette index (indexed color genomes)
+}
+
+// GenomeJSON is the saved form of an Individual
+type GenomeJSON struct {
+	Fitness float64    `json:"fitness"`
+	Genes   []GeneJSON `json:"genes"`
+	Palette [][4]uint8 `json:"palette,omitempty"` // RGBA (indexed color genomes)
+}
+
+// ToJSON returns the saved form of the individual
+func (ind *Individual) ToJSON() GenomeJSON {
+	gj := GenomeJSON{
+		Fitness: ind.fitness,
+		Genes:   make([]GeneJSON, 0, len(ind.genes)),
+	}
+	for _, c := range ind.palette {
+		gj.Palette = append(gj.Palette, [4]uint8{c.R, c.G, c.B, c.A})
+	}
+	for _, g := range ind.genes {
+		vs := make([][2]int, 0, len(g.destVertices))
+		for _, pt := range g.destVertices {
+			vs = append(vs, [2]int{pt.X, pt.Y})
+		}
+		c := g.destColor
+		gj.Genes = append(gj.Genes, GeneJSON{
+			Vertices: vs,
+			Color:    [4]uint8{c.R, c.G, c.B, c.A},
+			Index:    g.index,
+		})
+	}
+	return gj
+}
+
+// IndividualFromJSON creates an (unevaluated) individual from its saved form
+func IndividualFromJSON(src *ImageTarget, gj GenomeJSON) (*Individual, error) {
+	if len(gj.Genes) < 1 {
+		return nil, errors.New("Genome has no genes")
+	}
+
+	ind := NewIndividual(src, len(gj.Genes))
+	for _, c := range gj.Palette {
+		ind.palette = append(ind.palette, color.NRGBA{R: c[0], G: c[1], B: c[2], A: c[3]})
+	}
+	for idx, g := range gj.Genes {
+		if len(g.Vertices) < 3 {
+			return nil, errors.New("Gene has less than 3 vertices")
+		}
+		if gj.Palette != nil && (g.Index < 0 || g.Index >= len(gj.Palette)) {
+			return nil, errors.New("Gene palette index is out of range")
+		}
+		vs := make([]image.Point, 0, len(g.Vertices))
+		for _, v := range g.Vertices {
+			vs = append(vs, image.Pt(v[0], v[1]))
+		}
+		ind.genes[idx] = &Gene{
+			destVertices: vs,
+			destColor:    &color.NRGBA{R: g.Color[0], G: g.Color[1], B: g.Color[2], A: g.Color[3]},
+			index:        g.Index,
+		}
+	}
+	if ind.palette != nil {
+		ind.applyPalette()
+	}
+	return ind, nil
+}
@@ -0,0 +1,297 @@
+package evo
+
+import (
+	"encoding/json"
+	"flag"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Run with -update to rewrite the golden renders and fitness values after an
+// intentional change to rendering or fitness:
+//
+//	go test ./evo -run TestGolden -update
+var updateGoldens = flag.Bool("update", false, "Rewrite the golden images and fitness values")
+
+const (
+	goldenDir       = "testdata/golden"
+	goldenFailedDir = "testdata/failed" // Visual diffs of failed tests go here
+	goldenFitness   = "fitness.json"
+
+	pixelTolerance   = 2    // Max difference in any channel (0-255)
+	fitnessTolerance = 1e-4 // Max absolute difference in fitness (0-100)
+)
+
+// goldenCase is a fixed genome drawn over a synthetic target
+type goldenCase struct {
+	name   string
+	target *ImageTarget
+	genes  []GeneJSON
+}
+
+// seededGenes creates count genes from a fixed seed (seeded sources give the
+// same sequence in every Go version)
+func seededGenes(seed int64, count int, w, h int) []GeneJSON {
+	r := rand.New(rand.NewSource(seed))
+	genes := make([]GeneJSON, count)
+	for i := range genes {
+		for v := 0; v < 3; v++ {
+			genes[i].Vertices = append(genes[i].Vertices, [2]int{r.Intn(w + 1), r.Intn(h + 1)})
+		}
+		genes[i].Color = [4]uint8{uint8(r.Intn(256)), uint8(r.Intn(256)), uint8(r.Intn(256)), uint8(r.Intn(256))}
+	}
+	return genes
+}
+
+// goldenCases are the fixed genomes. Every target has a clear most common
+// color since that is the background of the render.
+func goldenCases() []goldenCase {
+	return []goldenCase{
+		{
+			name: "solid-one",
+			target: newTestTarget(32, 32, func(x, y int) color.NRGBA {
+				return color.NRGBA{R: 128, G: 128, B: 128, A: 255}
+			}),
+			genes: []GeneJSON{
+				{Vertices: [][2]int{{4, 4}, {28, 8}, {12, 27}}, Color: [4]uint8{220, 30, 30, 255}},
+			},
+		},
+		{
+			name: "gradient-overlap",
+			target: newTestTarget(32, 32, func(x, y int) color.NRGBA {
+				if y < 20 {
+					return color.NRGBA{R: uint8(x * 8), G: uint8(255 - x*8), B: 60, A: 255}
+				}
+				return color.NRGBA{R: 20, G: 40, B: 200, A: 255}
+			}),
+			genes: []GeneJSON{
+				{Vertices: [][2]int{{0, 0}, {24, 2}, {6, 30}}, Color: [4]uint8{255, 0, 0, 128}},
+				{Vertices: [][2]int{{10, 4}, {31, 16}, {8, 26}}, Color: [4]uint8{0, 255, 0, 96}},
+				{Vertices: [][2]int{{16, 0}, {32, 32}, {2, 20}}, Color: [4]uint8{0, 0, 255, 64}},
+			},
+		},
+		{
+			name: "checker-edges",
+			target: newTestTarget(30, 30, func(x, y int) color.NRGBA {
+				if (x/5+y/5)%3 == 0 {
+					return color.NRGBA{R: 10, G: 10, B: 10, A: 255}
+				}
+				return color.NRGBA{R: 240, G: 230, B: 200, A: 255}
+			}),
+			genes: []GeneJSON{
+				{Vertices: [][2]int{{0, 0}, {30, 0}, {0, 30}}, Color: [4]uint8{40, 40, 40, 200}},      // On the bounds
+				{Vertices: [][2]int{{30, 30}, {30, 10}, {10, 30}}, Color: [4]uint8{250, 250, 0, 255}}, // Max corner
+				{Vertices: [][2]int{{5, 5}, {15, 15}, {25, 25}}, Color: [4]uint8{0, 200, 200, 255}},   // No area
+				{Vertices: [][2]int{{2, 20}, {20, 2}, {28, 28}}, Color: [4]uint8{255, 0, 255, 0}},     // Invisible
+			},
+		},
+		{
+			name: "stripes-many",
+			target: newTestTarget(48, 32, func(x, y int) color.NRGBA {
+				if y%8 < 5 {
+					return color.NRGBA{R: 250, G: 200, B: 40, A: 255}
+				}
+				return color.NRGBA{R: 30, G: 90, B: 160, A: 255}
+			}),
+			genes: seededGenes(7, 24, 48, 32),
+		},
+	}
+}
+
+// loadGoldenFitness reads the expected fitness of every case
+func loadGoldenFitness(t *testing.T) map[string]float64 {
+	fitness := make(map[string]float64)
+	data, err := ioutil.ReadFile(filepath.Join(goldenDir, goldenFitness))
+	if os.IsNotExist(err) && *updateGoldens {
+		return fitness
+	}
+	if err != nil {
+		t.Fatalf("Could not read golden fitness values (run with -update to create them): %v", err)
+	}
+	if err := json.Unmarshal(data, &fitness); err != nil {
+		t.Fatalf("Bad golden fitness file: %v", err)
+	}
+	return fitness
+}
+
+// savePNG writes an image as PNG, creating the directory if needed
+func savePNG(fileName string, img image.Image) error {
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// loadPNG reads a PNG image
+func loadPNG(fileName string) (image.Image, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
+
+// imageDiff compares two images with a per channel tolerance. It returns the
+// number of pixels that differ by more than the tolerance and the largest
+// channel difference seen.
+func imageDiff(want, got image.Image, tolerance int) (bad int, worst int) {
+	b := want.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			d := pixelDiff(want.At(x, y), got.At(x, y))
+			if d > worst {
+				worst = d
+			}
+			if d > tolerance {
+				bad++
+			}
+		}
+	}
+	return bad, worst
+}
+
+// pixelDiff is the largest difference in any channel of two colors
+func pixelDiff(c1, c2 color.Color) int {
+	n1 := color.NRGBAModel.Convert(c1).(color.NRGBA)
+	n2 := color.NRGBAModel.Convert(c2).(color.NRGBA)
+	worst := 0
+	for _, d := range []int{
+		int(n1.R) - int(n2.R),
+		int(n1.G) - int(n2.G),
+		int(n1.B) - int(n2.B),
+		int(n1.A) - int(n2.A),
+	} {
+		if d < 0 {
+			d = -d
+		}
+		if d > worst {
+			worst = d
+		}
+	}
+	return worst
+}
+
+// diffImage shows the expected image, the actual image, and the difference
+// side by side. Pixels outside the tolerance are red, and the rest are a
+// faded copy of the expected image.
+func diffImage(want, got image.Image, tolerance int) image.Image {
+	b := want.Bounds()
+	w, h := b.Dx(), b.Dy()
+	out := image.NewNRGBA(image.Rect(0, 0, w*3+2, h))
+	draw.Draw(out, out.Bounds(), &image.Uniform{color.White}, image.ZP, draw.Src)
+	draw.Draw(out, image.Rect(0, 0, w, h), want, b.Min, draw.Src)
+	draw.Draw(out, image.Rect(w+1, 0, w*2+1, h), got, got.Bounds().Min, draw.Src)
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			wc, gc := want.At(b.Min.X+x, b.Min.Y+y), got.At(got.Bounds().Min.X+x, got.Bounds().Min.Y+y)
+			clr := color.NRGBA{R: 255, A: 255}
+			if pixelDiff(wc, gc) <= tolerance {
+				n := color.GrayModel.Convert(wc).(color.Gray)
+				v := 192 + n.Y/4
+				clr = color.NRGBA{R: v, G: v, B: v, A: 255}
+			}
+			out.SetNRGBA(w*2+2+x, y, clr)
+		}
+	}
+	return out
+}
+
+func TestGoldenRenders(t *testing.T) {
+	expected := loadGoldenFitness(t)
+
+	for _, gc := range goldenCases() {
+		ind, err := IndividualFromJSON(gc.target, GenomeJSON{Genes: gc.genes})
+		if err != nil {
+			t.Fatalf("%s: %v", gc.name, err)
+		}
+		fitness := ind.Fitness()
+		pngName := filepath.Join(goldenDir, gc.name+".png")
+
+		if *updateGoldens {
+			if err := savePNG(pngName, ind.Image()); err != nil {
+				t.Fatalf("%s: %v", gc.name, err)
+			}
+			expected[gc.name] = fitness
+			t.Logf("%s: updated golden image, fitness %f", gc.name, fitness)
+			continue
+		}
+
+		if want, ok := expected[gc.name]; !ok {
+			t.Errorf("%s: no golden fitness (run with -update)", gc.name)
+		} else if math.Abs(fitness-want) > fitnessTolerance {
+			t.Errorf("%s: fitness %.6f, expected %.6f", gc.name, fitness, want)
+		}
+
+		want, err := loadPNG(pngName)
+		if err != nil {
+			t.Errorf("%s: no golden image (run with -update): %v", gc.name, err)
+			continue
+		}
+		if want.Bounds().Size() != ind.Image().Bounds().Size() {
+			t.Errorf("%s: render is %v, golden image is %v", gc.name, ind.Image().Bounds().Size(), want.Bounds().Size())
+			continue
+		}
+		if bad, worst := imageDiff(want, ind.Image(), pixelTolerance); bad > 0 {
+			diffName := filepath.Join(goldenFailedDir, gc.name+"-diff.png")
+			if err := savePNG(diffName, diffImage(want, ind.Image(), pixelTolerance)); err != nil {
+				t.Logf("%s: could not write diff: %v", gc.name, err)
+			}
+			t.Errorf("%s: %d pixels differ by more than %d (worst %d): see %s", gc.name, bad, pixelTolerance, worst, diffName)
+		}
+	}
+
+	if *updateGoldens {
+		data, err := json.MarshalIndent(expected, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(goldenDir, goldenFitness), append(data, '\n'), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestImageDiff(t *testing.T) {
+	a := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	b := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	a.SetNRGBA(1, 1, color.NRGBA{R: 100, A: 255})
+	b.SetNRGBA(1, 1, color.NRGBA{R: 102, A: 255})
+	a.SetNRGBA(2, 3, color.NRGBA{G: 10, A: 255})
+	b.SetNRGBA(2, 3, color.NRGBA{G: 50, A: 255})
+
+	if bad, worst := imageDiff(a, b, 2); bad != 1 || worst != 40 {
+		t.Errorf("Expected 1 bad pixel with worst 40, got %d and %d", bad, worst)
+	}
+	if bad, _ := imageDiff(a, a, 0); bad != 0 {
+		t.Errorf("Image differs from itself in %d pixels", bad)
+	}
+
+	d := diffImage(a, b, 2)
+	if d.Bounds().Dx() != 14 || d.Bounds().Dy() != 4 {
+		t.Errorf("Diff image is %v", d.Bounds())
+	}
+	if c := color.NRGBAModel.Convert(d.At(10+2, 3)).(color.NRGBA); c.R != 255 || c.G != 0 {
+		t.Errorf("Bad pixel should be red in the diff, got %v", c)
+	}
+	if c := color.NRGBAModel.Convert(d.At(10+1, 1)).(color.NRGBA); c.G == 0 {
+		t.Errorf("Pixel within tolerance should not be red, got %v", c)
+	}
+}
@@ -0,0 +1,150 @@
+package evo
+
+import (
+	"errors"
+	"image"
+	"math"
+)
+
+// The gradient term of the fitness compares the edges of a render with the
+// edges of the target: at every pixel we compare the luminance gradient
+// magnitude and orientation. It is blended with the color term by weight.
+
+// GradientOperator is a 3x3 derivative kernel. The x kernel is
+//
+//	-Side 0 Side
+//	-Mid  0 Mid
+//	-Side 0 Side
+//
+// and the y kernel is its transpose.
+type GradientOperator struct {
+	Name string
+	Side float64
+	Mid  float64
+}
+
+// The gradient operators we support
+var (
+	Sobel  = GradientOperator{Name: "sobel", Side: 1.0, Mid: 2.0}
+	Scharr = GradientOperator{Name: "scharr", Side: 3.0, Mid: 10.0} // Better rotational symmetry
+)
+
+// NewGradientOperator returns the named gradient operator: sobel or scharr
+func NewGradientOperator(name string) (GradientOperator, bool) {
+	switch name {
+	case "sobel":
+		return Sobel, true
+	case "scharr":
+		return Scharr, true
+	}
+	return GradientOperator{}, false
+}
+
+// maxGradient is the largest possible gradient magnitude: gradients are
+// scaled so that each component is at most 255
+const maxGradient = 255.0 * math.Sqrt2
+
+// gradientMap holds the x and y gradients of every pixel in row order
+type gradientMap struct {
+	gx, gy []float64
+}
+
+// gradients calculates the luminance gradients of an image
+func (op GradientOperator) gradients(img *image.NRGBA) gradientMap {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	lum := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := img.NRGBAAt(b.Min.X+x, b.Min.Y+y)
+			lum[y*w+x] = 0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B)
+		}
+	}
+
+	// Edge pixels use the nearest pixel inside the image
+	at := func(x, y int) float64 {
+		if x < 0 {
+			x = 0
+		} else if x >= w {
+			x = w - 1
+		}
+		if y < 0 {
+			y = 0
+		} else if y >= h {
+			y = h - 1
+		}
+		return lum[y*w+x]
+	}
+
+	norm := 2.0*op.Side + op.Mid
+	gm := gradientMap{gx: make([]float64, w*h), gy: make([]float64, w*h)}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			gx := op.Side*(at(x+1, y-1)-at(x-1, y-1)) + op.Mid*(at(x+1, y)-at(x-1, y)) + op.Side*(at(x+1, y+1)-at(x-1, y+1))
+			gy := op.Side*(at(x-1, y+1)-at(x-1, y-1)) + op.Mid*(at(x, y+1)-at(x, y-1)) + op.Side*(at(x+1, y+1)-at(x+1, y-1))
+			gm.gx[y*w+x] = gx / norm
+			gm.gy[y*w+x] = gy / norm
+		}
+	}
+	return gm
+}
+
+// gradientError compares two gradients: the difference in magnitude plus
+// the orientation difference (ignoring edge polarity) scaled by the smaller
+// magnitude. The result is between 0 and the larger magnitude.
+func gradientError(gx1, gy1, gx2, gy2 float64) float64 {
+	m1 := math.Sqrt(gx1*gx1 + gy1*gy1)
+	m2 := math.Sqrt(gx2*gx2 + gy2*gy2)
+	if m1 == 0.0 || m2 == 0.0 {
+		return math.Abs(m1 - m2)
+	}
+	cos := math.Abs(gx1*gx2+gy1*gy2) / (m1 * m2)
+	return math.Abs(m1-m2) + math.Min(m1, m2)*(1.0-math.Min(cos, 1.0))
+}
+
+// SetGradient turns on the gradient term of the fitness: fitness becomes
+// (1-weight) * color + weight * gradient, where both terms are on the 0-100
+// scale. A weight of 0 turns the gradient term off. Like the weights, set this
+// before evaluating anything.
+func (it *ImageTarget) SetGradient(op GradientOperator, weight float64) error {
+	if weight < 0.0 || weight > 1.0 {
+		return errors.New("Invalid gradient weight - must be between 0 and 1")
+	}
+	if weight == 0.0 {
+		it.gradWeight = 0.0
+		it.gradOp = GradientOperator{}
+		it.gradMap = gradientMap{}
+		return nil
+	}
+	if op.Side <= 0.0 || op.Mid <= 0.0 {
+		return errors.New("Invalid gradient operator")
+	}
+
+	it.gradWeight = weight
+	it.gradOp = op
+	it.gradMap = op.gradients(it.imageData)
+	return nil
+}
+
+// GradientWeight is the weight of the gradient term (0 if it's off)
+func (it *ImageTarget) GradientWeight() float64 {
+	return it.gradWeight
+}
+
+// gradientFitness is the gradient term for a render: the (weighted) sum of
+// the gradient error scaled to 0-100
+func (it *ImageTarget) gradientFitness(img *image.NRGBA) float64 {
+	gm := it.gradOp.gradients(img)
+	tot, most := 0.0, 0.0
+	for i := range gm.gx {
+		d := gradientError(gm.gx[i], gm.gy[i], it.gradMap.gx[i], it.gradMap.gy[i])
+		w := 1.0
+		if it.weights != nil {
+			w = it.weights[i]
+		}
+		tot += d * w
+		most += maxGradient * w
+	}
+	return tot / most * 100.0
+}
@@ -0,0 +1,101 @@
+package evo
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// edgeImage is black on the left of edge and white from edge on
+func edgeImage(w, h, edge int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := uint8(0)
+			if x >= edge {
+				v = 255
+			}
+			img.SetNRGBA(x, y, color.NRGBA{R: v, G: v, B: v, A: 255})
+		}
+	}
+	return img
+}
+
+func TestGradients(t *testing.T) {
+	for _, op := range []GradientOperator{Sobel, Scharr} {
+		gm := op.gradients(edgeImage(8, 8, 4))
+
+		// Only the pixels either side of the edge have a gradient, and it
+		// points across the edge at full strength
+		for x := 0; x < 8; x++ {
+			gx, gy := gm.gx[3*8+x], gm.gy[3*8+x]
+			exp := 0.0
+			if x == 3 || x == 4 {
+				exp = 255.0
+			}
+			if math.Abs(gx-exp) > 1e-9 || gy != 0.0 {
+				t.Errorf("%s: x=%d gradient (%f, %f), expected (%f, 0)", op.Name, x, gx, gy, exp)
+			}
+		}
+	}
+
+	if _, ok := NewGradientOperator("canny"); ok {
+		t.Error("Unknown operator should fail")
+	}
+}
+
+func TestGradientError(t *testing.T) {
+	cases := []struct {
+		gx1, gy1, gx2, gy2 float64
+		exp                float64
+	}{
+		{10, 0, 10, 0, 0},   // Same
+		{10, 0, -10, 0, 0},  // Opposite polarity is the same edge
+		{10, 0, 0, 10, 10},  // Perpendicular
+		{10, 0, 4, 0, 6},    // Weaker
+		{0, 0, 0, 7, 7},     // Missing edge
+		{3, 4, 0, 0, 5},     // Extra edge
+		{10, 0, 0, 20, 20},  // Perpendicular and weaker: the larger magnitude
+		{1, 1, 2, 2, 1.414}, // Same direction
+	}
+	for _, c := range cases {
+		if got := gradientError(c.gx1, c.gy1, c.gx2, c.gy2); math.Abs(got-c.exp) > 0.001 {
+			t.Errorf("gradientError(%v, %v, %v, %v) = %f, expected %f", c.gx1, c.gy1, c.gx2, c.gy2, got, c.exp)
+		}
+	}
+}
+
+func TestGradientFitness(t *testing.T) {
+	target := NewImageTargetFromImage("edge", edgeImage(20, 10, 10))
+	if err := target.SetGradient(Sobel, 1.5); err == nil {
+		t.Error("Expected an error for a weight over 1")
+	}
+	if err := target.SetGradient(Sobel, 0.5); err != nil {
+		t.Fatal(err)
+	}
+
+	// A perfect render has no gradient error, and a render with the edge in
+	// the wrong place is worse than one with the edge slightly off
+	if f := target.gradientFitness(edgeImage(20, 10, 10)); f != 0.0 {
+		t.Errorf("Perfect render has gradient fitness %f", f)
+	}
+	near := target.gradientFitness(edgeImage(20, 10, 11))
+	far := target.gradientFitness(edgeImage(20, 10, 16))
+	flat := target.gradientFitness(edgeImage(20, 10, 20))
+	if !(near < far && far <= 100.0 && flat < far) {
+		t.Errorf("Gradient fitness near %f, far %f, flat %f", near, far, flat)
+	}
+
+	// Fitness is the blend of the two terms
+	ind := NewIndividual(target, 0)
+	blended := ind.Fitness()
+	colorTerm := (blended - 0.5*target.gradientFitness(ind.imageData.(*image.NRGBA))) / 0.5
+	if err := target.SetGradient(Sobel, 0.0); err != nil || target.GradientWeight() != 0.0 {
+		t.Fatal("Could not turn off the gradient term")
+	}
+	ind.needImage = true
+	if plain := ind.Fitness(); math.Abs(plain-colorTerm) > 1e-9 {
+		t.Errorf("Color term of the blend is %f, but color only fitness is %f", colorTerm, plain)
+	}
+}
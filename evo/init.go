@@ -0,0 +1,241 @@
+package evo
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// InitOptions control how NewGene creates random genes (for the initial
+// population and for immigrants). The zero value (and DefaultInitOptions) is
+// the original behavior: vertices uniform over the canvas, a uniform random
+// color, and an alpha of 0 (invisible). Indexed color genomes (SetPalette)
+// always use opaque palette colors, so the alpha range doesn't apply to them.
+type InitOptions struct {
+	Color     string  // random, or target: the target's color under the triangle's centroid
+	Size      string  // canvas (vertices anywhere), or the triangle radius distribution: fixed, normal or lognormal
+	SizeMean  float64 // Mean triangle radius as a fraction of the larger image side (0 for the default)
+	SizeSD    float64 // Radius standard deviation (a fraction of the larger side for normal, in log space for lognormal)
+	AlphaMin  uint8   // Initial alpha is uniform in [AlphaMin, AlphaMax]
+	AlphaMax  uint8
+	Placement string // uniform, or variance: favor the busy (high color variance) parts of the target
+}
+
+// defaultInitSizeMean is the SizeMean used when it is 0
+const defaultInitSizeMean = 0.1
+
+// DefaultInitOptions returns the original initialization
+func DefaultInitOptions() InitOptions {
+	return InitOptions{
+		Color:     "random",
+		Size:      "canvas",
+		SizeMean:  defaultInitSizeMean,
+		SizeSD:    0.05,
+		Placement: "uniform",
+	}
+}
+
+// Validate checks that the init options make sense
+func (o InitOptions) Validate() error {
+	switch {
+	case o.Color != "" && o.Color != "random" && o.Color != "target":
+		return errors.New("Invalid init color - must be random or target")
+	case o.Size != "" && o.Size != "canvas" && o.Size != "fixed" && o.Size != "normal" && o.Size != "lognormal":
+		return errors.New("Invalid init size - must be canvas, fixed, normal or lognormal")
+	case o.Placement != "" && o.Placement != "uniform" && o.Placement != "variance":
+		return errors.New("Invalid init placement - must be uniform or variance")
+	case o.SizeMean < 0.0 || o.SizeMean > 1.0:
+		return errors.New("Invalid init size mean - must be between 0 and 1")
+	case o.SizeSD < 0.0:
+		return errors.New("Init size standard deviation must be >= 0")
+	case o.AlphaMin > o.AlphaMax:
+		return errors.New("Init alpha min must be <= max")
+	}
+	return nil
+}
+
+// SetInit sets how new genes are created. Like the other target settings,
+// set this before creating the population.
+func (it *ImageTarget) SetInit(opts InitOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	if opts.SizeMean == 0.0 {
+		opts.SizeMean = defaultInitSizeMean
+	}
+	it.init = opts
+	it.varianceCDF = nil
+	if opts.Placement == "variance" {
+		it.varianceCDF = varianceCDF(it.imageData)
+	}
+	return nil
+}
+
+// Init returns the current init options
+func (it *ImageTarget) Init() InitOptions {
+	return it.init
+}
+
+// varianceRadius is the radius of the window used for local color variance
+const varianceRadius = 3
+
+// varianceCDF returns the cumulative distribution over pixels (in row order)
+// with probability proportional to the local color variance. A floor of a
+// tenth of the mean variance keeps flat regions possible.
+func varianceCDF(img *image.NRGBA) []float64 {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	// Integral images of each channel and its square
+	stride := w + 1
+	var sum, sq [3][]float64
+	for ch := 0; ch < 3; ch++ {
+		sum[ch] = make([]float64, stride*(h+1))
+		sq[ch] = make([]float64, stride*(h+1))
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := img.NRGBAAt(b.Min.X+x, b.Min.Y+y)
+			for ch, v := range [3]float64{float64(c.R), float64(c.G), float64(c.B)} {
+				i := (y+1)*stride + x + 1
+				sum[ch][i] = v + sum[ch][i-1] + sum[ch][i-stride] - sum[ch][i-stride-1]
+				sq[ch][i] = v*v + sq[ch][i-1] + sq[ch][i-stride] - sq[ch][i-stride-1]
+			}
+		}
+	}
+	box := func(a []float64, x0, y0, x1, y1 int) float64 {
+		return a[y1*stride+x1] - a[y0*stride+x1] - a[y1*stride+x0] + a[y0*stride+x0]
+	}
+
+	variance := make([]float64, w*h)
+	tot := 0.0
+	for y := 0; y < h; y++ {
+		y0, y1 := imax(0, y-varianceRadius), imin(h, y+varianceRadius+1)
+		for x := 0; x < w; x++ {
+			x0, x1 := imax(0, x-varianceRadius), imin(w, x+varianceRadius+1)
+			n := float64((x1 - x0) * (y1 - y0))
+			v := 0.0
+			for ch := 0; ch < 3; ch++ {
+				mean := box(sum[ch], x0, y0, x1, y1) / n
+				v += math.Max(0.0, box(sq[ch], x0, y0, x1, y1)/n-mean*mean)
+			}
+			variance[y*w+x] = v
+			tot += v
+		}
+	}
+
+	floor := tot / float64(w*h) * 0.1
+	if floor <= 0.0 {
+		floor = 1.0 // A flat target: uniform placement
+	}
+	cdf := make([]float64, w*h)
+	run := 0.0
+	for i, v := range variance {
+		run += v + floor
+		cdf[i] = run
+	}
+	for i := range cdf {
+		cdf[i] /= run
+	}
+	return cdf
+}
+
+func imin(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func imax(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// initPoint returns a random point for a new gene: uniform over the canvas
+// (the max edge included, like the original NewGene) or from the variance
+// distribution
+func (it *ImageTarget) initPoint() image.Point {
+	b := it.imageData.Bounds()
+	if it.varianceCDF == nil {
+		return image.Pt(rand.Intn(b.Dx()+1)+b.Min.X, rand.Intn(b.Dy()+1)+b.Min.Y)
+	}
+	idx := sort.SearchFloat64s(it.varianceCDF, rand.Float64())
+	if idx >= len(it.varianceCDF) {
+		idx = len(it.varianceCDF) - 1
+	}
+	return image.Pt(b.Min.X+idx%b.Dx(), b.Min.Y+idx/b.Dx())
+}
+
+// initRadius returns a triangle radius in pixels from the size distribution
+func (it *ImageTarget) initRadius() float64 {
+	b := it.imageData.Bounds()
+	side := float64(imax(b.Dx(), b.Dy()))
+	opts := it.init
+
+	r := opts.SizeMean
+	switch opts.Size {
+	case "normal":
+		r = opts.SizeMean + rand.NormFloat64()*opts.SizeSD
+	case "lognormal":
+		r = opts.SizeMean * math.Exp(rand.NormFloat64()*opts.SizeSD)
+	}
+	return math.Max(1.0, r*side)
+}
+
+// initVertices returns the vertices of a new triangle. For the canvas size
+// every vertex is a random point, and otherwise the vertices are at a random
+// radius and angle around a random center.
+func (it *ImageTarget) initVertices() []image.Point {
+	if it.init.Size == "" || it.init.Size == "canvas" {
+		return []image.Point{it.initPoint(), it.initPoint(), it.initPoint()}
+	}
+
+	b := it.imageData.Bounds()
+	center := it.initPoint()
+	vs := make([]image.Point, 3)
+	for i := range vs {
+		r := it.initRadius()
+		a := rand.Float64() * 2.0 * math.Pi
+		x := float64(center.X) + r*math.Cos(a)
+		y := float64(center.Y) + r*math.Sin(a)
+		vs[i] = image.Pt(
+			int(math.Max(float64(b.Min.X), math.Min(float64(b.Max.X), math.Round(x)))),
+			int(math.Max(float64(b.Min.Y), math.Min(float64(b.Max.Y), math.Round(y)))),
+		)
+	}
+	return vs
+}
+
+// initColor returns the color of a new gene with vertices vs
+func (it *ImageTarget) initColor(vs []image.Point) color.NRGBA {
+	var clr color.NRGBA
+	if it.init.Color == "target" {
+		b := it.imageData.Bounds()
+		cx, cy := 0, 0
+		for _, v := range vs {
+			cx += v.X
+			cy += v.Y
+		}
+		cx = imax(b.Min.X, imin(b.Max.X-1, cx/len(vs)))
+		cy = imax(b.Min.Y, imin(b.Max.Y-1, cy/len(vs)))
+		clr = it.imageData.NRGBAAt(cx, cy)
+	} else {
+		clr = color.NRGBA{
+			R: uint8(rand.Intn(256)),
+			G: uint8(rand.Intn(256)),
+			B: uint8(rand.Intn(256)),
+		}
+	}
+
+	clr.A = it.init.AlphaMin
+	if it.init.AlphaMax > it.init.AlphaMin {
+		clr.A += uint8(rand.Intn(int(it.init.AlphaMax-it.init.AlphaMin) + 1))
+	}
+	return clr
+}
@@ -0,0 +1,227 @@
+package evo
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+// halfBusyTarget is flat gray on the left and a 1 pixel checkerboard on the
+// right
+func halfBusyTarget() *ImageTarget {
+	return newTestTarget(40, 20, func(x, y int) color.NRGBA {
+		if x < 20 || (x+y)%2 == 0 {
+			return color.NRGBA{R: 128, G: 128, B: 128, A: 255}
+		}
+		return color.NRGBA{R: 250, G: 10, B: 10, A: 255}
+	})
+}
+
+// checkInBounds makes sure all of a gene's vertices are on the canvas
+func checkInBounds(t *testing.T, target *ImageTarget, g *Gene) {
+	b := target.imageData.Bounds()
+	for _, v := range g.destVertices {
+		if v.X < b.Min.X || v.X > b.Max.X || v.Y < b.Min.Y || v.Y > b.Max.Y {
+			t.Fatalf("Vertex %v outside of %v", v, b)
+		}
+	}
+}
+
+// centroidColor is the target color under a gene's centroid
+func centroidColor(target *ImageTarget, g *Gene) color.NRGBA {
+	b := target.imageData.Bounds()
+	cx, cy := 0, 0
+	for _, v := range g.destVertices {
+		cx += v.X
+		cy += v.Y
+	}
+	cx = imax(b.Min.X, imin(b.Max.X-1, cx/len(g.destVertices)))
+	cy = imax(b.Min.Y, imin(b.Max.Y-1, cy/len(g.destVertices)))
+	return target.imageData.NRGBAAt(cx, cy)
+}
+
+func TestInitDefault(t *testing.T) {
+	target := halfBusyTarget()
+	b := target.imageData.Bounds()
+
+	// The defaults must use the random numbers just like the original
+	// NewGene so that old seeds give the same runs
+	for _, opts := range []InitOptions{{}, DefaultInitOptions()} {
+		if err := target.SetInit(opts); err != nil {
+			t.Fatal(err)
+		}
+		rand.Seed(42)
+		var genes []*Gene
+		for i := 0; i < 20; i++ {
+			genes = append(genes, NewGene(target))
+		}
+
+		rand.Seed(42)
+		for i, g := range genes {
+			var vs []image.Point
+			for j := 0; j < 3; j++ {
+				vs = append(vs, image.Pt(rand.Intn(b.Dx()+1)+b.Min.X, rand.Intn(b.Dy()+1)+b.Min.Y))
+			}
+			clr := color.NRGBA{R: uint8(rand.Intn(256)), G: uint8(rand.Intn(256)), B: uint8(rand.Intn(256))}
+			exp := &Gene{destVertices: vs, destColor: &clr}
+			if !geneEqual(g, exp) {
+				t.Fatalf("Gene %d is %v %v, expected %v %v", i, g.destVertices, *g.destColor, vs, clr)
+			}
+		}
+	}
+}
+
+func TestInitTargetColor(t *testing.T) {
+	target := halfBusyTarget()
+	opts := DefaultInitOptions()
+	opts.Color = "target"
+	opts.AlphaMin = 100
+	opts.AlphaMax = 120
+	if err := target.SetInit(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	rand.Seed(42)
+	for i := 0; i < 200; i++ {
+		g := NewGene(target)
+		checkInBounds(t, target, g)
+
+		clr, exp := *g.destColor, centroidColor(target, g)
+		if clr.R != exp.R || clr.G != exp.G || clr.B != exp.B {
+			t.Fatalf("Gene color %v, expected target color %v under %v", clr, exp, g.destVertices)
+		}
+		if clr.A < 100 || clr.A > 120 {
+			t.Fatalf("Gene alpha %d outside of [100,120]", clr.A)
+		}
+	}
+}
+
+func TestInitSize(t *testing.T) {
+	target := newTestTarget(100, 50, func(x, y int) color.NRGBA {
+		return color.NRGBA{R: 10, G: 20, B: 30, A: 255}
+	})
+	for _, size := range []string{"fixed", "normal", "lognormal"} {
+		opts := DefaultInitOptions()
+		opts.Size = size
+		opts.SizeMean = 0.1
+		if err := target.SetInit(opts); err != nil {
+			t.Fatal(err)
+		}
+
+		rand.Seed(42)
+		tot := 0.0
+		for i := 0; i < 500; i++ {
+			g := NewGene(target)
+			checkInBounds(t, target, g)
+			tot += g.Area()
+
+			// A fixed radius of 10 pixels: every vertex is within 20 pixels
+			// (plus rounding) of the others
+			if size == "fixed" {
+				for _, v1 := range g.destVertices {
+					for _, v2 := range g.destVertices {
+						if dx, dy := v1.X-v2.X, v1.Y-v2.Y; dx*dx+dy*dy > 21*21 {
+							t.Fatalf("Vertices %v are too far apart for radius 10", g.destVertices)
+						}
+					}
+				}
+			}
+		}
+
+		// Much smaller than triangles with vertices anywhere (about 1/12 of
+		// the canvas on average)
+		if mean := tot / 500.0; mean > 150.0 {
+			t.Errorf("%s: mean area %f is too big for a radius of 10", size, mean)
+		}
+	}
+}
+
+func TestInitVariancePlacement(t *testing.T) {
+	target := halfBusyTarget()
+	opts := DefaultInitOptions()
+	opts.Size = "fixed"
+	opts.SizeMean = 0.01 // Tiny triangles: the vertices are at the center
+	opts.Placement = "variance"
+	if err := target.SetInit(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	rand.Seed(42)
+	busy := 0
+	for i := 0; i < 1000; i++ {
+		g := NewGene(target)
+		if g.destVertices[0].X >= 20 {
+			busy++
+		}
+	}
+	// The flat half only has the floor (and the window spilling over)
+	if busy < 800 {
+		t.Errorf("Only %d of 1000 genes were placed in the busy half", busy)
+	}
+
+	// A flat target falls back to uniform
+	cdf := varianceCDF(newTestTarget(10, 10, func(x, y int) color.NRGBA {
+		return color.NRGBA{A: 255}
+	}).imageData)
+	for i, p := range cdf {
+		if exp := float64(i+1) / 100.0; p < exp-1e-9 || p > exp+1e-9 {
+			t.Fatalf("Flat CDF[%d] is %f, expected %f", i, p, exp)
+		}
+	}
+}
+
+func TestInitPaletteTargetColor(t *testing.T) {
+	target := threeColorTarget()
+	if err := target.SetPalette(3, false); err != nil {
+		t.Fatal(err)
+	}
+	opts := DefaultInitOptions()
+	opts.Color = "target"
+	opts.Size = "fixed"
+	opts.SizeMean = 0.01
+	if err := target.SetInit(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// Tiny triangles get the palette color of the target under them
+	rand.Seed(42)
+	ind := indexedIndividual(target, 50)
+	checkPaletteColors(t, ind)
+	for i, g := range ind.genes {
+		if exp := centroidColor(target, g); *g.destColor != exp {
+			t.Fatalf("Gene %d at %v has %v, expected %v", i, g.destVertices, *g.destColor, exp)
+		}
+	}
+}
+
+func TestSetInitErrors(t *testing.T) {
+	target := halfBusyTarget()
+	bad := []func(o *InitOptions){
+		func(o *InitOptions) { o.Color = "blue" },
+		func(o *InitOptions) { o.Size = "huge" },
+		func(o *InitOptions) { o.Placement = "center" },
+		func(o *InitOptions) { o.SizeMean = -0.1 },
+		func(o *InitOptions) { o.SizeMean = 1.5 },
+		func(o *InitOptions) { o.SizeSD = -1.0 },
+		func(o *InitOptions) { o.AlphaMin, o.AlphaMax = 10, 5 },
+	}
+	for i, change := range bad {
+		opts := DefaultInitOptions()
+		change(&opts)
+		if err := target.SetInit(opts); err == nil {
+			t.Errorf("Bad options %d (%+v) were accepted", i, opts)
+		}
+	}
+	if err := target.SetInit(DefaultInitOptions()); err != nil {
+		t.Errorf("Default options failed: %v", err)
+	}
+
+	// The zero value is the default
+	if err := target.SetInit(InitOptions{}); err != nil {
+		t.Errorf("Zero options failed: %v", err)
+	}
+	if mean := target.Init().SizeMean; mean != DefaultInitOptions().SizeMean {
+		t.Errorf("Zero options have a size mean of %f", mean)
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package evo
 
 import (
 	"image"
@@ -50,20 +50,33 @@ func Mutation(ind *Individual, rate float64) *Individual {
 		return p
 	}
 
+	// indexed colors: mutate the palette (if it evolves) and the indexes
+	// instead of the gene colors
+	indexed := ind.palette != nil
+	if indexed && ind.target.evolvePalette {
+		mutatePalette(ind.palette, rate)
+	}
+
 	for _, curr := range ind.genes {
-		// colors
-		clr = curr.destColor
-		if rand.Float64() <= rate {
-			clr.R = mutateColorCoord(clr.R)
-		}
-		if rand.Float64() <= rate {
-			clr.G = mutateColorCoord(clr.G)
-		}
-		if rand.Float64() <= rate {
-			clr.B = mutateColorCoord(clr.B)
-		}
-		if rand.Float64() <= rate {
-			clr.A = mutateColorCoord(clr.A)
+		if indexed {
+			if len(ind.palette) > 1 && rand.Float64() <= rate {
+				curr.index = mutateIndex(curr.index, len(ind.palette))
+			}
+		} else {
+			// colors
+			clr = curr.destColor
+			if rand.Float64() <= rate {
+				clr.R = mutateColorCoord(clr.R)
+			}
+			if rand.Float64() <= rate {
+				clr.G = mutateColorCoord(clr.G)
+			}
+			if rand.Float64() <= rate {
+				clr.B = mutateColorCoord(clr.B)
+			}
+			if rand.Float64() <= rate {
+				clr.A = mutateColorCoord(clr.A)
+			}
 		}
 
 		// vertices
@@ -74,12 +87,16 @@ func Mutation(ind *Individual, rate float64) *Individual {
 		}
 	}
 
+	if indexed {
+		ind.applyPalette()
+	}
 	return ind
 }
 
 // Shuffle provides a complete shuffle of the genome (since order matters)
 func Shuffle(ind *Individual) *Individual {
 	clone := NewIndividual(ind.target, len(ind.genes))
+	clone.palette = copyPalette(ind.palette)
 	for write, read := range rand.Perm(len(clone.genes)) {
 		clone.genes[write] = ind.genes[read].Copy()
 	}
@@ -0,0 +1,165 @@
+package evo
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"testing/quick"
+)
+
+func TestMutateNormBounds(t *testing.T) {
+	// Any source in any range (including sd of 0 and huge sd) stays in the
+	// range, and moves by at least 1 unless it was clamped
+	prop := func(a, b, c int16, sd uint16) bool {
+		mn, mx := float64(a), float64(b)
+		if mn > mx {
+			mn, mx = mx, mn
+		}
+		src := math.Max(mn, math.Min(mx, float64(c)))
+
+		v := mutateNorm(src, float64(sd), mn, mx)
+		if v < mn || v > mx {
+			return false
+		}
+		return math.Abs(v-src) >= 1.0 || v == mn || v == mx
+	}
+	if err := quick.Check(prop, quickConfig()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMutateNormMinDelta(t *testing.T) {
+	rand.Seed(1)
+	for i := 0; i < 1000; i++ {
+		v := mutateNorm(10.0, 0.01, 0.0, 20.0)
+		if v != 9.0 && v != 11.0 {
+			t.Fatalf("Tiny sd should move by exactly 1: got %f", v)
+		}
+	}
+
+	if v := mutateNorm(10.0, 0.0, 0.0, 20.0); v != 11.0 {
+		t.Errorf("Zero sd should move up by 1: got %f", v)
+	}
+}
+
+func TestMutateNormClamp(t *testing.T) {
+	rand.Seed(1)
+	for i := 0; i < 1000; i++ {
+		if v := mutateNorm(0.0, 1000.0, 0.0, 255.0); v != 0.0 && v != 255.0 && (v < 1.0 || v > 255.0) {
+			t.Fatalf("Out of range: %f", v)
+		}
+		if v := mutateNorm(255.0, 0.0, 0.0, 255.0); v != 255.0 {
+			t.Fatalf("Should clamp to max: %f", v)
+		}
+	}
+}
+
+func TestMutationBounds(t *testing.T) {
+	b := testTarget.imageData.Bounds()
+	prop := func(tg testGenome) bool {
+		before := copyGenes(tg.ind)
+		Mutation(tg.ind, 1.0)
+
+		for i, g := range tg.ind.genes {
+			for j, p := range g.destVertices {
+				if p.X < b.Min.X || p.X > b.Max.X || p.Y < b.Min.Y || p.Y > b.Max.Y {
+					return false
+				}
+
+				// Every coordinate moved unless it was pinned at an edge
+				old := before[i].destVertices[j]
+				if p.X == old.X && p.X != b.Min.X && p.X != b.Max.X {
+					return false
+				}
+				if p.Y == old.Y && p.Y != b.Min.Y && p.Y != b.Max.Y {
+					return false
+				}
+			}
+
+			oc, nc := before[i].destColor, g.destColor
+			for _, ch := range [][2]uint8{{oc.R, nc.R}, {oc.G, nc.G}, {oc.B, nc.B}, {oc.A, nc.A}} {
+				if ch[0] == ch[1] && ch[0] != 0 && ch[0] != 255 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	if err := quick.Check(prop, quickConfig()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMutationZeroRate(t *testing.T) {
+	prop := func(tg testGenome) bool {
+		before := copyGenes(tg.ind)
+		Mutation(tg.ind, 0.0)
+		for i, g := range tg.ind.genes {
+			if !geneEqual(g, before[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(prop, quickConfig()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestShuffle(t *testing.T) {
+	prop := func(tg testGenome) bool {
+		ind := tg.ind
+		before := copyGenes(ind)
+		clone := Shuffle(ind)
+
+		if clone == ind || len(clone.genes) != len(ind.genes) || clone.target != ind.target {
+			return false
+		}
+
+		// The original is untouched
+		for i, g := range ind.genes {
+			if !geneEqual(g, before[i]) {
+				return false
+			}
+		}
+
+		// Every original gene is used exactly once, as a deep copy
+		used := make([]bool, len(ind.genes))
+		for _, c := range clone.genes {
+			found := false
+			for i, g := range ind.genes {
+				if geneShared(g, c) {
+					return false
+				}
+				if !used[i] && geneEqual(g, c) {
+					used[i] = true
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(prop, quickConfig()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestShuffleOrder(t *testing.T) {
+	// With enough genes a shuffle shouldn't keep the order
+	rand.Seed(1)
+	ind := randIndividual(rand.New(rand.NewSource(1)), 50)
+	clone := Shuffle(ind)
+	same := 0
+	for i, g := range ind.genes {
+		if geneEqual(g, clone.genes[i]) {
+			same++
+		}
+	}
+	if same > 10 {
+		t.Errorf("Shuffle left %d of 50 genes in place", same)
+	}
+}
@@ -0,0 +1,16 @@
+package evo
+
+// Observer is told about every generation by Engine.Step, after the
+// generation has been evaluated and the next one bred. Observers are called
+// in the order they were added and must not change the population.
+type Observer interface {
+	Observe(gen *Generation) error
+}
+
+// ObserverFunc lets an ordinary function be an Observer
+type ObserverFunc func(gen *Generation) error
+
+// Observe calls f(gen)
+func (f ObserverFunc) Observe(gen *Generation) error {
+	return f(gen)
+}
@@ -0,0 +1,257 @@
+package evo
+
+import (
+	"errors"
+	"image/color"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// Indexed color genomes: instead of a free RGBA color, every gene uses one
+// of the K colors in its individual's palette. Palette colors are opaque, and
+// palette[0] is the background, so a render only uses the palette colors
+// (plus anti-aliasing at the edges). The palette is either fixed (k-means
+// clustering of the target's pixels) or evolved with the genome, starting
+// from the k-means palette.
+
+// maxPaletteSamples limits the pixels used for k-means on large targets
+const maxPaletteSamples = 20000
+
+// KMeansPalette clusters the target's pixels (in RGB) into at most k distinct
+// opaque colors, sorted from the biggest cluster to the smallest. The clustering uses its
+// own seeded random numbers, so a target always gets the same palette.
+func KMeansPalette(target *ImageTarget, k int) []color.NRGBA {
+	img := target.imageData
+	b := img.Bounds()
+	r := rand.New(rand.NewSource(1))
+
+	step := 1
+	if n := b.Dx() * b.Dy(); n > maxPaletteSamples {
+		step = (n + maxPaletteSamples - 1) / maxPaletteSamples
+	}
+	var pixels [][3]float64
+	for i := 0; i < b.Dx()*b.Dy(); i += step {
+		c := img.NRGBAAt(b.Min.X+i%b.Dx(), b.Min.Y+i/b.Dx())
+		pixels = append(pixels, [3]float64{float64(c.R), float64(c.G), float64(c.B)})
+	}
+
+	dist2 := func(p, q [3]float64) float64 {
+		dr, dg, db := p[0]-q[0], p[1]-q[1], p[2]-q[2]
+		return dr*dr + dg*dg + db*db
+	}
+	nearest := func(p [3]float64, centers [][3]float64) (int, float64) {
+		best, bestDist := 0, math.Inf(1)
+		for i, c := range centers {
+			if d := dist2(p, c); d < bestDist {
+				best, bestDist = i, d
+			}
+		}
+		return best, bestDist
+	}
+
+	// k-means++ seeding: each new center is picked with probability
+	// proportional to its squared distance from the closest center so far
+	centers := [][3]float64{pixels[r.Intn(len(pixels))]}
+	dists := make([]float64, len(pixels))
+	for len(centers) < k {
+		tot := 0.0
+		for i, p := range pixels {
+			_, dists[i] = nearest(p, centers)
+			tot += dists[i]
+		}
+		if tot <= 0.0 {
+			break // Fewer distinct colors than k
+		}
+		pick := r.Float64() * tot
+		idx := 0
+		for ; idx < len(pixels)-1 && pick > dists[idx]; idx++ {
+			pick -= dists[idx]
+		}
+		centers = append(centers, pixels[idx])
+	}
+
+	// Lloyd's iterations until nothing moves
+	assign := make([]int, len(pixels))
+	counts := make([]int, len(centers))
+	for iter := 0; iter < 100; iter++ {
+		moved := iter == 0
+		for i, p := range pixels {
+			if c, _ := nearest(p, centers); c != assign[i] {
+				assign[i] = c
+				moved = true
+			}
+		}
+		if !moved {
+			break
+		}
+
+		sums := make([][3]float64, len(centers))
+		for i := range counts {
+			counts[i] = 0
+		}
+		for i, p := range pixels {
+			c := assign[i]
+			counts[c]++
+			for ch := range p {
+				sums[c][ch] += p[ch]
+			}
+		}
+		for c := range centers {
+			if counts[c] > 0 {
+				for ch := range sums[c] {
+					centers[c][ch] = sums[c][ch] / float64(counts[c])
+				}
+			}
+		}
+	}
+
+	order := make([]int, len(centers))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return counts[order[i]] > counts[order[j]] })
+
+	// Empty clusters and duplicate colors are dropped (so a target with
+	// fewer than k colors gets a smaller palette)
+	palette := make([]color.NRGBA, 0, k)
+	seen := make(map[color.NRGBA]bool)
+	for _, c := range order {
+		ctr := centers[c]
+		clr := color.NRGBA{
+			R: uint8(math.Round(ctr[0])),
+			G: uint8(math.Round(ctr[1])),
+			B: uint8(math.Round(ctr[2])),
+			A: 255,
+		}
+		if counts[c] > 0 && !seen[clr] {
+			seen[clr] = true
+			palette = append(palette, clr)
+		}
+	}
+	return palette
+}
+
+// SetPalette switches new individuals (see RandInit) to indexed colors with
+// a palette of up to k colors from KMeansPalette. If evolve is true, the palette is
+// part of the genome and is mutated and crossed over. A k of 0 switches back
+// to free colors.
+func (it *ImageTarget) SetPalette(k int, evolve bool) error {
+	if k == 0 {
+		it.palette = nil
+		it.evolvePalette = false
+		return nil
+	}
+	if k < 2 || k > 256 {
+		return errors.New("Invalid palette size - must be between 2 and 256")
+	}
+	it.palette = KMeansPalette(it, k)
+	it.evolvePalette = evolve
+	return nil
+}
+
+// Palette returns the k-means palette for new individuals (nil for free
+// colors)
+func (it *ImageTarget) Palette() []color.NRGBA {
+	return it.palette
+}
+
+// EvolvePalette is true if palettes are evolved with the genome
+func (it *ImageTarget) EvolvePalette() bool {
+	return it.evolvePalette
+}
+
+// Palette returns the individual's palette (nil if it uses free colors)
+func (ind *Individual) Palette() []color.NRGBA {
+	return ind.palette
+}
+
+// Background is the color a render starts with: the target's most common
+// color, or the first palette color
+func (ind *Individual) Background() color.NRGBA {
+	if ind.palette != nil {
+		return ind.palette[0]
+	}
+	return ind.target.ImageMode()
+}
+
+// copyPalette returns a copy of a palette (nil stays nil)
+func copyPalette(palette []color.NRGBA) []color.NRGBA {
+	if palette == nil {
+		return nil
+	}
+	return append([]color.NRGBA(nil), palette...)
+}
+
+// initPalette gives a new individual the target's palette (with a little
+// mutation if palettes evolve, so that the population starts with some
+// variety) and palette indexes for its genes: random, or the closest palette
+// color to the gene's target color (see InitOptions.Color)
+func (ind *Individual) initPalette() {
+	ind.palette = copyPalette(ind.target.palette)
+	if ind.target.evolvePalette {
+		mutatePalette(ind.palette, 1.0)
+	}
+	for _, g := range ind.genes {
+		if ind.target.init.Color == "target" {
+			g.index = nearestPaletteIndex(ind.palette, *g.destColor)
+		} else {
+			g.index = rand.Intn(len(ind.palette))
+		}
+	}
+	ind.applyPalette()
+}
+
+// applyPalette sets the color of every gene from its palette index
+func (ind *Individual) applyPalette() {
+	for _, g := range ind.genes {
+		*g.destColor = ind.palette[g.index]
+	}
+}
+
+// remapPalette points every gene at the palette color closest to its current
+// color (genes that already have their color keep their index)
+func (ind *Individual) remapPalette() {
+	for _, g := range ind.genes {
+		if ind.palette[g.index] != *g.destColor {
+			g.index = nearestPaletteIndex(ind.palette, *g.destColor)
+		}
+	}
+}
+
+// nearestPaletteIndex returns the palette color closest to clr
+func nearestPaletteIndex(palette []color.NRGBA, clr color.NRGBA) int {
+	best, bestDist := 0, math.Inf(1)
+	for i, pc := range palette {
+		if d := colorDist(pc, clr); d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return best
+}
+
+// mutateIndex switches a palette index to one of the other n-1 indexes
+func mutateIndex(idx int, n int) int {
+	other := rand.Intn(n - 1)
+	if other >= idx {
+		other++
+	}
+	return other
+}
+
+// mutatePalette mutates the RGB of each palette color at the given rate
+// (palette colors stay opaque)
+func mutatePalette(palette []color.NRGBA, rate float64) {
+	for i := range palette {
+		clr := &palette[i]
+		if rand.Float64() <= rate {
+			clr.R = mutateColorCoord(clr.R)
+		}
+		if rand.Float64() <= rate {
+			clr.G = mutateColorCoord(clr.G)
+		}
+		if rand.Float64() <= rate {
+			clr.B = mutateColorCoord(clr.B)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package evo
+
+import (
+	"image/color"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+var (
+	paletteRed   = color.NRGBA{R: 200, G: 20, B: 20, A: 255}
+	paletteBlue  = color.NRGBA{R: 10, G: 30, B: 220, A: 255}
+	paletteWhite = color.NRGBA{R: 250, G: 250, B: 250, A: 255}
+)
+
+// threeColorTarget is 50% red, 30% blue and 20% white
+func threeColorTarget() *ImageTarget {
+	return newTestTarget(10, 10, func(x, y int) color.NRGBA {
+		switch {
+		case y < 5:
+			return paletteRed
+		case y < 8:
+			return paletteBlue
+		}
+		return paletteWhite
+	})
+}
+
+// indexedIndividual creates a random individual using the target's palette
+func indexedIndividual(target *ImageTarget, geneCount int) *Individual {
+	ind := NewIndividual(target, geneCount)
+	ind.RandInit()
+	return ind
+}
+
+// checkPaletteColors makes sure every gene has its palette color
+func checkPaletteColors(t *testing.T, ind *Individual) {
+	for i, g := range ind.genes {
+		if g.index < 0 || g.index >= len(ind.palette) {
+			t.Fatalf("Gene %d has index %d with a palette of %d", i, g.index, len(ind.palette))
+		}
+		if *g.destColor != ind.palette[g.index] {
+			t.Fatalf("Gene %d color %v isn't palette color %v", i, *g.destColor, ind.palette[g.index])
+		}
+	}
+}
+
+func TestKMeansPalette(t *testing.T) {
+	target := threeColorTarget()
+	exp := []color.NRGBA{paletteRed, paletteBlue, paletteWhite}
+	if palette := KMeansPalette(target, 3); !reflect.DeepEqual(palette, exp) {
+		t.Errorf("Palette %v, expected %v", palette, exp)
+	}
+
+	// Asking for more colors than the target has gives a smaller palette
+	// (no duplicates)
+	palette := KMeansPalette(target, 5)
+	if !reflect.DeepEqual(palette, exp) {
+		t.Errorf("Palette of 5 is %v", palette)
+	}
+
+	// Two clusters: red and blue are closest, so they merge (weighted by
+	// their pixel counts)
+	palette = KMeansPalette(target, 2)
+	merged := color.NRGBA{R: 129, G: 24, B: 95, A: 255}
+	if palette[0] != merged || palette[1] != paletteWhite {
+		t.Errorf("Palette of 2 is %v", palette)
+	}
+}
+
+func TestSetPalette(t *testing.T) {
+	target := threeColorTarget()
+	for _, k := range []int{-1, 1, 257} {
+		if err := target.SetPalette(k, false); err == nil {
+			t.Errorf("Expected an error for a palette of %d", k)
+		}
+	}
+	if err := target.SetPalette(3, true); err != nil || len(target.Palette()) != 3 || !target.EvolvePalette() {
+		t.Fatalf("Could not set the palette: %v", err)
+	}
+	if err := target.SetPalette(0, false); err != nil || target.Palette() != nil || target.EvolvePalette() {
+		t.Error("Palette of 0 should switch back to free colors")
+	}
+}
+
+func TestIndexedRender(t *testing.T) {
+	rand.Seed(1)
+	target := threeColorTarget()
+	if err := target.SetPalette(3, false); err != nil {
+		t.Fatal(err)
+	}
+	ind := indexedIndividual(target, 6)
+	checkPaletteColors(t, ind)
+	if ind.Background() != paletteRed {
+		t.Errorf("Background %v should be the first palette color", ind.Background())
+	}
+
+	// Apart from anti-aliased edges, only palette colors are drawn
+	ind.Fitness()
+	img := ind.imageData.(interface{ NRGBAAt(x, y int) color.NRGBA })
+	inPalette := 0
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 10; x++ {
+			for _, c := range ind.palette {
+				if img.NRGBAAt(x, y) == c {
+					inPalette++
+					break
+				}
+			}
+		}
+	}
+	if inPalette < 50 {
+		t.Errorf("Only %d of 100 pixels are palette colors", inPalette)
+	}
+}
+
+func TestIndexedMutation(t *testing.T) {
+	rand.Seed(1)
+	target := threeColorTarget()
+	if err := target.SetPalette(3, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// A fixed palette never changes, but every index does at rate 1
+	ind := indexedIndividual(target, 20)
+	before := copyGenes(ind)
+	Mutation(ind, 1.0)
+	checkPaletteColors(t, ind)
+	if !reflect.DeepEqual(ind.palette, target.Palette()) {
+		t.Errorf("Fixed palette changed: %v", ind.palette)
+	}
+	for i, g := range ind.genes {
+		if g.index == before[i].index {
+			t.Errorf("Gene %d index didn't change", i)
+		}
+	}
+
+	// An evolved palette changes, but stays opaque
+	if err := target.SetPalette(3, true); err != nil {
+		t.Fatal(err)
+	}
+	ind = indexedIndividual(target, 20)
+	palette := copyPalette(ind.palette)
+	Mutation(ind, 1.0)
+	checkPaletteColors(t, ind)
+	if reflect.DeepEqual(ind.palette, palette) {
+		t.Error("Evolved palette didn't change")
+	}
+	for _, c := range ind.palette {
+		if c.A != 255 {
+			t.Errorf("Palette color %v isn't opaque", c)
+		}
+	}
+}
+
+func TestIndexedCrossover(t *testing.T) {
+	rand.Seed(1)
+	target := threeColorTarget()
+	if err := target.SetPalette(3, true); err != nil {
+		t.Fatal(err)
+	}
+	p1, p2 := indexedIndividual(target, 10), indexedIndividual(target, 10)
+
+	// Rate 1 swaps every gene and palette color
+	c1, c2 := Crossover(p1, p2, 1.0)
+	if !reflect.DeepEqual(c1.palette, p2.palette) || !reflect.DeepEqual(c2.palette, p1.palette) {
+		t.Error("Palettes should be swapped")
+	}
+	if &c1.palette[0] == &p2.palette[0] {
+		t.Error("Child palette isn't a copy")
+	}
+	checkPaletteColors(t, c1)
+	checkPaletteColors(t, c2)
+
+	// Swapping everything keeps every gene's color
+	for i := range c1.genes {
+		if *c1.genes[i].destColor != *p2.genes[i].destColor || *c2.genes[i].destColor != *p1.genes[i].destColor {
+			t.Errorf("Gene %d changed color", i)
+		}
+	}
+
+	// Otherwise each gene gets the child palette color closest to its color
+	// in its parent (reversing one palette makes the same index a different
+	// color)
+	for i, j := 0, len(p2.palette)-1; i < j; i, j = i+1, j-1 {
+		p2.palette[i], p2.palette[j] = p2.palette[j], p2.palette[i]
+	}
+	p2.applyPalette()
+	for iter := 0; iter < 20; iter++ {
+		c1, c2 = Crossover(p1, p2, 0.5)
+		for _, pair := range [][3]*Individual{{c1, p1, p2}, {c2, p2, p1}} {
+			child := pair[0]
+			checkPaletteColors(t, child)
+			for i, g := range child.genes {
+				parent := pair[1]
+				if !reflect.DeepEqual(g.destVertices, parent.genes[i].destVertices) {
+					parent = pair[2]
+				}
+				exp := child.palette[nearestPaletteIndex(child.palette, *parent.genes[i].destColor)]
+				if *g.destColor != exp {
+					t.Fatalf("Gene %d has %v, expected %v (closest to %v)", i, *g.destColor, exp, *parent.genes[i].destColor)
+				}
+			}
+		}
+	}
+
+	clone := Shuffle(p1)
+	if !reflect.DeepEqual(clone.palette, p1.palette) {
+		t.Error("Shuffle lost the palette")
+	}
+}
+
+func TestPaletteJSON(t *testing.T) {
+	rand.Seed(1)
+	target := threeColorTarget()
+	if err := target.SetPalette(3, true); err != nil {
+		t.Fatal(err)
+	}
+	ind := indexedIndividual(target, 8)
+	Mutation(ind, 0.5)
+
+	gj := ind.ToJSON()
+	back, err := IndividualFromJSON(target, gj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(back.palette, ind.palette) {
+		t.Errorf("Palette %v, expected %v", back.palette, ind.palette)
+	}
+	for i, g := range back.genes {
+		if !geneEqual(g, ind.genes[i]) || g.index != ind.genes[i].index {
+			t.Errorf("Gene %d changed", i)
+		}
+	}
+
+	gj.Genes[0].Index = 3
+	if _, err := IndividualFromJSON(target, gj); err == nil {
+		t.Error("Expected an error for an index outside the palette")
+	}
+}
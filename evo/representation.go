@@ -1,24 +1,24 @@
-package main
+package evo
 
 import (
 	"image"
 	"image/color"
 	"image/draw"
 	"image/jpeg"
+	"image/png"
 	"log"
 	"math"
-	"math/rand"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 
 	"github.com/llgcode/draw2d/draw2dimg"
 )
 
-// TODO: make sure adaptive stuff is properly documented
 // TODO: update docs about fitness scaled by max fitness
 // TODO: update docs about guassian mutation
-// TODO: allow size limiting of triangles AND report size across solutions
-// TODO: need table with triangle count and size with outputs (final fitness score?)
-// TODO: consider a goroutine for logging once we add triangle size/count to log
+// TODO: allow size limiting of triangles
 
 //////////////////////////////////////////////////////////////////////////
 // Helpers
@@ -38,11 +38,20 @@ func colorDist(c1 color.NRGBA, c2 color.NRGBA) float64 {
 
 // ImageTarget is the image we are actually trying to reproduce
 type ImageTarget struct {
-	fileName   string
-	imageData  *image.NRGBA
-	imageMode  *color.NRGBA
-	imageMean  *color.NRGBA
-	maxFitness float64
+	fileName      string
+	imageData     *image.NRGBA
+	imageMode     *color.NRGBA
+	imageMean     *color.NRGBA
+	maxFitness    float64
+	weights       []float64        // Per pixel error weights (nil for unweighted)
+	gradWeight    float64          // Weight of the gradient term (0 for color only)
+	gradOp        GradientOperator // Gradient operator for the gradient term
+	gradMap       gradientMap      // Target gradients for the gradient term
+	palette       []color.NRGBA    // Palette for new individuals (nil for free colors)
+	evolvePalette bool             // Palettes are part of the genome
+	init          InitOptions      // How NewGene creates genes
+	varianceCDF   []float64        // Pixel distribution for variance placement (nil for uniform)
+	evals         uint64           // Fitness evaluations so far: use atomic access
 }
 
 // NewImageTarget creates a new ImageTarget instance from the JPEG file
@@ -58,26 +67,33 @@ func NewImageTarget(fileName string) (*ImageTarget, error) {
 		return nil, err
 	}
 
+	target := NewImageTargetFromImage(fileName, simg)
+	log.Printf("%s %v %v (mf=%f)\n", fileName, target.imageData.ColorModel(), target.imageData.Bounds(), target.maxFitness)
+	return target, nil
+}
+
+// NewImageTargetFromImage creates a new ImageTarget from an image in memory
+// (e.g. a synthetic target). The name is used in place of a file name.
+func NewImageTargetFromImage(name string, src image.Image) *ImageTarget {
 	// Make sure that the image is actually in NRGBA format
-	b := simg.Bounds()
+	b := src.Bounds()
 	img := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
-	draw.Draw(img, img.Bounds(), simg, b.Min, draw.Src)
+	draw.Draw(img, img.Bounds(), src, b.Min, draw.Src)
 
-	// Calculate max fitness
-	b = img.Bounds()
+	return &ImageTarget{
+		fileName:   name,
+		imageData:  img,
+		maxFitness: maxFitness(img.Bounds()),
+	}
+}
+
+// maxFitness is the (unweighted) maximum error for an image of size b
+func maxFitness(b image.Rectangle) float64 {
 	yrng := (b.Max.Y - b.Min.Y) + 1
 	xrng := (b.Max.X - b.Min.X) + 1
 	pixCount := float64(xrng * yrng)
 	oneMax := math.Sqrt(255.0 * 255.0 * 3.0) // 255 squared times 3 for RGB
-	maxFit := pixCount * oneMax
-
-	log.Printf("%s %v %v (mf=%f)\n", fileName, img.ColorModel(), img.Bounds(), maxFit)
-
-	return &ImageTarget{
-		fileName:   fileName,
-		imageData:  img,
-		maxFitness: maxFit,
-	}, nil
+	return pixCount * oneMax
 }
 
 func (it *ImageTarget) calcStats() {
@@ -124,6 +140,21 @@ func (it *ImageTarget) calcStats() {
 	it.imageMean = &meanClr
 }
 
+// Evals returns the number of fitness evaluations performed against the target
+func (it *ImageTarget) Evals() uint64 {
+	return atomic.LoadUint64(&it.evals)
+}
+
+// FileName is the file the target was loaded from
+func (it *ImageTarget) FileName() string {
+	return it.fileName
+}
+
+// Image returns the target image
+func (it *ImageTarget) Image() image.Image {
+	return it.imageData
+}
+
 // ImageMode returns the most common color in the image (use as a background color)
 func (it *ImageTarget) ImageMode() color.NRGBA {
 	if it.imageMode == nil {
@@ -149,28 +180,15 @@ func (it *ImageTarget) ImageMean() color.NRGBA {
 type Gene struct {
 	destVertices []image.Point
 	destColor    *color.NRGBA
+	index        int // Palette index (if the individual has a palette)
 }
 
 // NewGene creates a random gene instance
 func NewGene(src *ImageTarget) *Gene {
-	b := src.imageData.Bounds()
-	yrng := (b.Max.Y - b.Min.Y) + 1
-	xrng := (b.Max.X - b.Min.X) + 1
-
-	// Create a triangle (a series of 3 points)
-	vs := []image.Point{
-		image.Pt(rand.Intn(xrng)+b.Min.X, rand.Intn(yrng)+b.Min.Y),
-		image.Pt(rand.Intn(xrng)+b.Min.X, rand.Intn(yrng)+b.Min.Y),
-		image.Pt(rand.Intn(xrng)+b.Min.X, rand.Intn(yrng)+b.Min.Y),
-	}
-
-	// Create random color with alpha=0 (totally transparent)
-	var clr color.NRGBA = color.NRGBA{
-		R: uint8(rand.Intn(256)),
-		G: uint8(rand.Intn(256)),
-		B: uint8(rand.Intn(256)),
-		A: uint8(0),
-	}
+	// Create a triangle (a series of 3 points) and its color: by default
+	// random with alpha=0 (totally transparent), see SetInit
+	vs := src.initVertices()
+	clr := src.initColor(vs)
 
 	return &Gene{
 		destVertices: vs,
@@ -178,11 +196,30 @@ func NewGene(src *ImageTarget) *Gene {
 	}
 }
 
+// invisibleAlpha is the alpha at or below which we consider a gene invisible
+const invisibleAlpha = 2
+
+// Area returns the area of the gene's polygon (using the shoelace formula)
+func (g *Gene) Area() float64 {
+	area := 0
+	for idx, p1 := range g.destVertices {
+		p2 := g.destVertices[(idx+1)%len(g.destVertices)]
+		area += (p1.X * p2.Y) - (p2.X * p1.Y)
+	}
+	return math.Abs(float64(area)) / 2.0
+}
+
+// Visible is true if the gene has a non-trivial alpha
+func (g *Gene) Visible() bool {
+	return g.destColor.A > invisibleAlpha
+}
+
 // Copy returns a pointer to a proper deep copy of a Gene
 func (g *Gene) Copy() *Gene {
 	newg := Gene{
 		destVertices: make([]image.Point, len(g.destVertices)),
 		destColor:    new(color.NRGBA),
+		index:        g.index,
 	}
 	copy(newg.destVertices, g.destVertices)
 	*newg.destColor = *g.destColor
@@ -199,6 +236,7 @@ type Individual struct {
 	imageData image.Image
 	needImage bool
 	genes     []*Gene
+	palette   []color.NRGBA // Gene colors for indexed color genomes (nil for free colors)
 }
 
 // NewIndividual creates a random individual
@@ -218,6 +256,9 @@ func (ind *Individual) RandInit() {
 	for i := 0; i < len(ind.genes); i++ {
 		ind.genes[i] = NewGene(ind.target)
 	}
+	if ind.target.palette != nil {
+		ind.initPalette()
+	}
 }
 
 // Fitness calculates the individual's fitness score (to be minimized) using lazy and cached evaluation
@@ -226,9 +267,14 @@ func (ind *Individual) Fitness() float64 {
 		return ind.fitness
 	}
 
-	// init image: color entire rectange from src.ImageMode
+	// indexed colors: make sure the genes have their palette colors
+	if ind.palette != nil {
+		ind.applyPalette()
+	}
+
+	// init image: color entire rectange from src.ImageMode (or the palette)
 	img := image.NewNRGBA(ind.target.imageData.Bounds())
-	draw.Draw(img, img.Bounds(), &image.Uniform{ind.target.ImageMode()}, image.ZP, draw.Src)
+	draw.Draw(img, img.Bounds(), &image.Uniform{ind.Background()}, image.ZP, draw.Src)
 
 	// Make sure that the image is actually in RGBA format for draw2d
 	b := img.Bounds()
@@ -260,20 +306,32 @@ func (ind *Individual) Fitness() float64 {
 	draw.Draw(img, img.Bounds(), img2d, b.Min, draw.Src)
 
 	// calculate fitness - the sum of the color distance pixel by pixel
+	// (multiplied by the pixel's weight if the target has weights)
 	fitness := float64(0.0)
+	weights := ind.target.weights
 
 	b = img.Bounds()
 	for y := b.Min.Y; y < b.Max.Y; y++ {
 		for x := b.Min.X; x < b.Max.X; x++ {
 			c1 := img.NRGBAAt(x, y)
 			c2 := ind.target.imageData.NRGBAAt(x, y)
-			fitness += colorDist(c1, c2)
+			d := colorDist(c1, c2)
+			if weights != nil {
+				d *= weights[(y-b.Min.Y)*b.Dx()+(x-b.Min.X)]
+			}
+			fitness += d
 		}
 	}
 
 	// Scale by the maxmimum error
 	fitness = (fitness / ind.target.maxFitness) * 100.0
 
+	// Blend in edge alignment if we're using the gradient term
+	if w := ind.target.gradWeight; w > 0.0 {
+		fitness = (1.0-w)*fitness + w*ind.target.gradientFitness(img)
+	}
+	atomic.AddUint64(&ind.target.evals, 1)
+
 	// all done - store our results and return the fitness
 	ind.fitness = fitness
 	ind.imageData = img
@@ -281,26 +339,73 @@ func (ind *Individual) Fitness() float64 {
 	return ind.fitness
 }
 
-// Save the individual as a JPEG using the given file name
+// Image returns the rendered image of an evaluated individual (nil if it
+// hasn't been evaluated)
+func (ind *Individual) Image() image.Image {
+	return ind.imageData
+}
+
+// GeneStats summarizes the triangles in an individual
+type GeneStats struct {
+	AreaMean  float64
+	AreaMin   float64
+	AreaMax   float64
+	Invisible int // genes with alpha of about 0
+}
+
+// GeneStats returns triangle size stats and the number of invisible genes
+func (ind *Individual) GeneStats() GeneStats {
+	stats := GeneStats{}
+	if len(ind.genes) < 1 {
+		return stats
+	}
+
+	stats.AreaMin = math.MaxFloat64
+	for _, g := range ind.genes {
+		area := g.Area()
+		stats.AreaMean += area
+		stats.AreaMin = math.Min(stats.AreaMin, area)
+		stats.AreaMax = math.Max(stats.AreaMax, area)
+		if !g.Visible() {
+			stats.Invisible++
+		}
+	}
+	stats.AreaMean /= float64(len(ind.genes))
+
+	return stats
+}
+
+// Save the individual using the given file name. The image is written as a
+// (lossless) PNG if the file name ends in .png and as a JPEG otherwise. We
+// write to a temp file and rename, so an interrupted save never leaves a
+// half-written image behind.
 func (ind *Individual) Save(fileName string) error {
-	fimg, ferr := os.Create(fileName)
+	tmpName := fileName + ".tmp"
+	fimg, ferr := os.Create(tmpName)
 	if ferr != nil {
 		return ferr
 	}
-	defer fimg.Close()
 
-	opts := &jpeg.Options{
-		Quality: 99,
+	var ierr error
+	if strings.ToLower(filepath.Ext(fileName)) == ".png" {
+		ierr = png.Encode(fimg, ind.imageData)
+	} else {
+		opts := &jpeg.Options{
+			Quality: 99,
+		}
+		ierr = jpeg.Encode(fimg, ind.imageData, opts)
+	}
+	if cerr := fimg.Close(); ierr == nil {
+		ierr = cerr
 	}
-
-	ierr := jpeg.Encode(fimg, ind.imageData, opts)
 	if ierr != nil {
+		os.Remove(tmpName)
 		return ierr
 	}
 
 	//log.Printf("Wrote file %s\n", fileName)
 
-	return nil
+	return os.Rename(tmpName, fileName)
 }
 
 //////////////////////////////////////////////////////////////////////////
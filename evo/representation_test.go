@@ -0,0 +1,160 @@
+package evo
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+)
+
+// newTestTarget creates a target in memory with pixels from fill
+func newTestTarget(w, h int, fill func(x, y int) color.NRGBA) *ImageTarget {
+	return newSyntheticTarget("test", w, h, fill)
+}
+
+// testTarget is shared by the random genomes
+var testTarget = newTestTarget(32, 24, func(x, y int) color.NRGBA {
+	return color.NRGBA{R: uint8(x * 8), G: uint8(y * 10), B: 128, A: 255}
+})
+
+// testGenome is a random individual for testing/quick
+type testGenome struct {
+	ind *Individual
+}
+
+// randGene creates a random gene inside the target bounds from r
+func randGene(r *rand.Rand, target *ImageTarget) *Gene {
+	b := target.imageData.Bounds()
+	g := &Gene{destColor: &color.NRGBA{
+		R: uint8(r.Intn(256)),
+		G: uint8(r.Intn(256)),
+		B: uint8(r.Intn(256)),
+		A: uint8(r.Intn(256)),
+	}}
+	for i := 0; i < 3; i++ {
+		g.destVertices = append(g.destVertices, image.Pt(
+			b.Min.X+r.Intn(b.Dx()+1),
+			b.Min.Y+r.Intn(b.Dy()+1),
+		))
+	}
+	return g
+}
+
+// randIndividual creates an individual with geneCount random genes from r
+func randIndividual(r *rand.Rand, geneCount int) *Individual {
+	ind := NewIndividual(testTarget, geneCount)
+	for i := range ind.genes {
+		ind.genes[i] = randGene(r, testTarget)
+	}
+	return ind
+}
+
+// Generate implements quick.Generator
+func (testGenome) Generate(r *rand.Rand, size int) reflect.Value {
+	return reflect.ValueOf(testGenome{randIndividual(r, 1+r.Intn(size+1))})
+}
+
+// quickConfig makes the property tests repeatable
+func quickConfig() *quick.Config {
+	rand.Seed(42)
+	return &quick.Config{MaxCount: 200, Rand: rand.New(rand.NewSource(42))}
+}
+
+// geneEqual compares genes by value
+func geneEqual(a, b *Gene) bool {
+	return *a.destColor == *b.destColor && reflect.DeepEqual(a.destVertices, b.destVertices)
+}
+
+// geneShared is true if the genes share any memory
+func geneShared(a, b *Gene) bool {
+	return a == b || a.destColor == b.destColor || &a.destVertices[0] == &b.destVertices[0]
+}
+
+// copyGenes returns deep copies of an individual's genes
+func copyGenes(ind *Individual) []*Gene {
+	genes := make([]*Gene, len(ind.genes))
+	for i, g := range ind.genes {
+		genes[i] = g.Copy()
+	}
+	return genes
+}
+
+func TestGeneCopy(t *testing.T) {
+	prop := func(tg testGenome) bool {
+		for _, g := range tg.ind.genes {
+			c := g.Copy()
+			if !geneEqual(g, c) || geneShared(g, c) {
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(prop, quickConfig()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGeneCopyIndependent(t *testing.T) {
+	g := randGene(rand.New(rand.NewSource(1)), testTarget)
+	orig := g.Copy()
+
+	c := g.Copy()
+	c.destColor.R++
+	c.destColor.A++
+	c.destVertices[0].X++
+	c.destVertices[2].Y++
+	if !geneEqual(g, orig) {
+		t.Errorf("Changing a copy changed the original: %v %v", g.destVertices, *g.destColor)
+	}
+
+	g.destColor.G++
+	g.destVertices[1].X++
+	if c.destColor.G != orig.destColor.G || c.destVertices[1] != orig.destVertices[1] {
+		t.Errorf("Changing the original changed a copy: %v %v", c.destVertices, *c.destColor)
+	}
+}
+
+func TestCalcStats(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 255}
+	blue := color.NRGBA{B: 255, A: 255}
+	green := color.NRGBA{G: 200, A: 100}
+
+	// 16 pixels: 9 red, 5 blue, 2 green
+	target := newTestTarget(4, 4, func(x, y int) color.NRGBA {
+		switch i := y*4 + x; {
+		case i < 9:
+			return red
+		case i < 14:
+			return blue
+		}
+		return green
+	})
+
+	if mode := target.ImageMode(); mode != red {
+		t.Errorf("Mode is %v, expected %v", mode, red)
+	}
+
+	// Channels are averaged and truncated
+	expMean := color.NRGBA{
+		R: 143, // 9*255/16
+		G: 25,  // 2*200/16
+		B: 79,  // 5*255/16
+		A: 235, // (14*255 + 2*100)/16
+	}
+	if mean := target.ImageMean(); mean != expMean {
+		t.Errorf("Mean is %v, expected %v", mean, expMean)
+	}
+}
+
+func TestCalcStatsSolid(t *testing.T) {
+	prop := func(r, g, b, a uint8) bool {
+		clr := color.NRGBA{R: r, G: g, B: b, A: a}
+		target := newTestTarget(5, 3, func(x, y int) color.NRGBA { return clr })
+		return target.ImageMode() == clr && target.ImageMean() == clr
+	}
+	if err := quick.Check(prop, quickConfig()); err != nil {
+		t.Error(err)
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package evo
 
 import "math/rand"
 
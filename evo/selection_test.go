@@ -0,0 +1,43 @@
+package evo
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestSelectionBias(t *testing.T) {
+	const popSize = 10
+	const draws = 200000
+
+	pop := make(Population, popSize)
+	index := make(map[*Individual]int)
+	for i := range pop {
+		pop[i] = NewIndividual(testTarget, 0)
+		index[pop[i]] = i
+	}
+
+	rand.Seed(1)
+	for _, tournSize := range []int{1, 2, 3, 5} {
+		counts := make([]int, popSize)
+		for i := 0; i < draws; i++ {
+			counts[index[Selection(pop, tournSize)]]++
+		}
+
+		// Individual i wins if it's the best (lowest index) of tournSize
+		// uniform draws: P = ((n-i)/n)^k - ((n-i-1)/n)^k
+		for i, count := range counts {
+			n, k := float64(popSize), float64(tournSize)
+			exp := math.Pow((n-float64(i))/n, k) - math.Pow((n-float64(i)-1)/n, k)
+			got := float64(count) / draws
+			if math.Abs(got-exp) > 0.005 {
+				t.Errorf("Tournament %d: individual %d selected %.4f, expected %.4f", tournSize, i, got, exp)
+			}
+		}
+
+		// Bigger tournaments favor the best individual more
+		if tournSize > 1 && counts[0] <= counts[popSize-1] {
+			t.Errorf("Tournament %d: no bias towards the best (%v)", tournSize, counts)
+		}
+	}
+}
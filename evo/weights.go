@@ -0,0 +1,171 @@
+package evo
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+)
+
+// Weight maps make some pixels of the target count more than others in the
+// fitness sum: each pixel's color distance is multiplied by its weight. A
+// weight map has one weight (0 to 1) per target pixel in row order.
+
+// minWeight keeps the generated weight maps from ignoring any pixel entirely
+const minWeight = 0.1
+
+// SetWeights sets the per pixel weights used by Fitness (nil removes them).
+// Max fitness is scaled by the mean weight so that fitness stays on the
+// 0-100 scale. Set weights before evaluating anything: individuals that were
+// already evaluated keep their old fitness.
+func (it *ImageTarget) SetWeights(weights []float64) error {
+	b := it.imageData.Bounds()
+	if weights == nil {
+		it.weights = nil
+		it.maxFitness = maxFitness(b)
+		return nil
+	}
+
+	if len(weights) != b.Dx()*b.Dy() {
+		return errors.New("Weight map is not the same size as the target")
+	}
+	tot := 0.0
+	for _, w := range weights {
+		if w < 0.0 || math.IsNaN(w) || math.IsInf(w, 0) {
+			return errors.New("Weights must be non-negative")
+		}
+		tot += w
+	}
+	if tot <= 0.0 {
+		return errors.New("Weight map is empty (every weight is 0)")
+	}
+
+	it.weights = weights
+	it.maxFitness = maxFitness(b) * tot / float64(len(weights))
+	return nil
+}
+
+// Weights returns the per pixel weights (nil if fitness is unweighted)
+func (it *ImageTarget) Weights() []float64 {
+	return it.weights
+}
+
+// WeightImage returns the weights as a grayscale image (white is a weight of
+// 1), or nil if fitness is unweighted
+func (it *ImageTarget) WeightImage() *image.Gray {
+	if it.weights == nil {
+		return nil
+	}
+	b := it.imageData.Bounds()
+	img := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for i, w := range it.weights {
+		img.Pix[(i/b.Dx())*img.Stride+i%b.Dx()] = uint8(math.Min(w, 1.0)*255.0 + 0.5)
+	}
+	return img
+}
+
+// MaskWeights uses a grayscale version of mask as the weights: white pixels
+// have a weight of 1 and black pixels a weight of 0. A mask that isn't the
+// same size as the target is stretched to fit (nearest neighbor).
+func MaskWeights(target *ImageTarget, mask image.Image) []float64 {
+	b := target.imageData.Bounds()
+	mb := mask.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	weights := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		my := mb.Min.Y + y*mb.Dy()/h
+		for x := 0; x < w; x++ {
+			mx := mb.Min.X + x*mb.Dx()/w
+			g := color.Gray16Model.Convert(mask.At(mx, my)).(color.Gray16)
+			weights[y*w+x] = float64(g.Y) / 65535.0
+		}
+	}
+	return weights
+}
+
+// CenterWeights is a center bias: a Gaussian falloff from the middle of the
+// target (with a standard deviation of half the distance to the edges)
+func CenterWeights(target *ImageTarget) []float64 {
+	b := target.imageData.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	weights := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		dy := (float64(y)+0.5)/float64(h)*2.0 - 1.0
+		for x := 0; x < w; x++ {
+			dx := (float64(x)+0.5)/float64(w)*2.0 - 1.0
+			g := math.Exp(-(dx*dx + dy*dy) / (2.0 * 0.5 * 0.5))
+			weights[y*w+x] = minWeight + (1.0-minWeight)*g
+		}
+	}
+	return weights
+}
+
+// SaliencyWeights estimates which pixels stand out, using frequency-tuned
+// saliency (Achanta et al, 2009): the distance between each pixel of a
+// slightly blurred target and the target's mean color. Flat backgrounds get
+// low weights, and distinct details get high weights.
+func SaliencyWeights(target *ImageTarget) []float64 {
+	img := target.imageData
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	mean := target.ImageMean()
+
+	// 5x5 binomial blur (separable 1-4-6-4-1), clamped at the edges
+	kernel := []float64{1, 4, 6, 4, 1}
+	clamp := func(v, mx int) int {
+		if v < 0 {
+			return 0
+		} else if v >= mx {
+			return mx - 1
+		}
+		return v
+	}
+	blur := func(get func(x, y int) [3]float64, horiz bool) [][3]float64 {
+		out := make([][3]float64, w*h)
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				var sum [3]float64
+				for k, kw := range kernel {
+					px, py := x, y
+					if horiz {
+						px = clamp(x+k-2, w)
+					} else {
+						py = clamp(y+k-2, h)
+					}
+					c := get(px, py)
+					for ch := range sum {
+						sum[ch] += c[ch] * kw / 16.0
+					}
+				}
+				out[y*w+x] = sum
+			}
+		}
+		return out
+	}
+
+	pass := blur(func(x, y int) [3]float64 {
+		c := img.NRGBAAt(b.Min.X+x, b.Min.Y+y)
+		return [3]float64{float64(c.R), float64(c.G), float64(c.B)}
+	}, true)
+	smooth := blur(func(x, y int) [3]float64 { return pass[y*w+x] }, false)
+
+	weights := make([]float64, w*h)
+	most := 0.0
+	for i, c := range smooth {
+		dr := c[0] - float64(mean.R)
+		dg := c[1] - float64(mean.G)
+		db := c[2] - float64(mean.B)
+		weights[i] = math.Sqrt(dr*dr + dg*dg + db*db)
+		most = math.Max(most, weights[i])
+	}
+	for i := range weights {
+		s := 1.0
+		if most > 0.0 {
+			s = weights[i] / most
+		}
+		weights[i] = minWeight + (1.0-minWeight)*s
+	}
+	return weights
+}
@@ -0,0 +1,125 @@
+package evo
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestWeightsUniform(t *testing.T) {
+	// Uniform weights of any size give the same fitness as no weights
+	r := rand.New(rand.NewSource(3))
+	for _, w := range []float64{1.0, 0.25} {
+		target := newTestTarget(20, 16, func(x, y int) color.NRGBA {
+			return color.NRGBA{R: uint8(x * 10), G: uint8(y * 12), B: 90, A: 255}
+		})
+		ind := NewIndividual(target, 8)
+		for i := range ind.genes {
+			ind.genes[i] = randGene(r, target)
+		}
+		plain := ind.Fitness()
+
+		weights := make([]float64, 20*16)
+		for i := range weights {
+			weights[i] = w
+		}
+		if err := target.SetWeights(weights); err != nil {
+			t.Fatal(err)
+		}
+		ind.needImage = true
+		if weighted := ind.Fitness(); math.Abs(weighted-plain) > 1e-9 {
+			t.Errorf("Uniform weight %v: fitness %f, unweighted %f", w, weighted, plain)
+		}
+	}
+}
+
+func TestWeightsMask(t *testing.T) {
+	// Red is the most common color (so it's the background), and the blue
+	// on the right has no weight
+	target := newTestTarget(10, 10, func(x, y int) color.NRGBA {
+		if x < 6 {
+			return color.NRGBA{R: 200, A: 255}
+		}
+		return color.NRGBA{B: 200, A: 255}
+	})
+	mask := image.NewGray(image.Rect(0, 0, 5, 1))
+	for x := 0; x < 3; x++ {
+		mask.SetGray(x, 0, color.Gray{Y: 255})
+	}
+	weights := MaskWeights(target, mask)
+	if weights[0] != 1.0 || weights[5] != 1.0 || weights[6] != 0.0 || weights[99] != 0.0 {
+		t.Fatalf("Mask should be stretched to the target: %v", weights[:10])
+	}
+	if err := target.SetWeights(weights); err != nil {
+		t.Fatal(err)
+	}
+
+	// With no genes we just draw the background, which is only wrong where
+	// there is no weight
+	ind := NewIndividual(target, 0)
+	if f := ind.Fitness(); f != 0.0 {
+		t.Errorf("Only the zero weight pixels are wrong, but fitness is %f", f)
+	}
+
+	if target.WeightImage().GrayAt(9, 9).Y != 0 || target.WeightImage().GrayAt(0, 9).Y != 255 {
+		t.Error("Weight image doesn't match the weights")
+	}
+}
+
+func TestSetWeightsErrors(t *testing.T) {
+	target := newTestTarget(4, 4, func(x, y int) color.NRGBA { return color.NRGBA{A: 255} })
+	base := target.maxFitness
+
+	if err := target.SetWeights(make([]float64, 15)); err == nil {
+		t.Error("Expected an error for the wrong size")
+	}
+	if err := target.SetWeights(make([]float64, 16)); err == nil {
+		t.Error("Expected an error for all zero weights")
+	}
+	neg := make([]float64, 16)
+	neg[0], neg[1] = 2.0, -1.0
+	if err := target.SetWeights(neg); err == nil {
+		t.Error("Expected an error for a negative weight")
+	}
+
+	half := make([]float64, 16)
+	for i := range half {
+		half[i] = 0.5
+	}
+	if err := target.SetWeights(half); err != nil || math.Abs(target.maxFitness-base*0.5) > 1e-9 {
+		t.Errorf("Max fitness should be scaled by the mean weight: %f vs %f (%v)", target.maxFitness, base, err)
+	}
+	if err := target.SetWeights(nil); err != nil || target.maxFitness != base || target.Weights() != nil {
+		t.Error("Removing weights should restore max fitness")
+	}
+}
+
+func TestCenterWeights(t *testing.T) {
+	target := newTestTarget(21, 11, func(x, y int) color.NRGBA { return color.NRGBA{A: 255} })
+	weights := CenterWeights(target)
+	center, corner := weights[5*21+10], weights[0]
+	if center < 0.99 || corner < minWeight || corner > 0.2 {
+		t.Errorf("Center %f and corner %f", center, corner)
+	}
+}
+
+func TestSaliencyWeights(t *testing.T) {
+	// A flat background with a bright square in the middle
+	target := newTestTarget(30, 30, func(x, y int) color.NRGBA {
+		if x >= 12 && x < 18 && y >= 12 && y < 18 {
+			return color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+		}
+		return color.NRGBA{R: 40, G: 60, B: 80, A: 255}
+	})
+	weights := SaliencyWeights(target)
+	for _, w := range weights {
+		if w < minWeight || w > 1.0 {
+			t.Fatalf("Weight %f out of range", w)
+		}
+	}
+	if inside, outside := weights[15*30+15], weights[2*30+2]; inside < 0.99 || outside > 0.2 {
+		t.Errorf("Square %f should stand out from the background %f", inside, outside)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"compress/lzw"
+	"encoding/binary"
+	"image"
+	"image/draw"
+	"io"
+	"os"
+)
+
+// gifAnimator writes an animated GIF as the frames arrive, so a long run
+// doesn't keep every frame in memory (image/gif can only encode a whole
+// GIF at once). Each frame is quantized to its own 256 color palette. The
+// layout is:
+//
+//	'GIF89a' + logical screen descriptor (no global color table)
+//	NETSCAPE2.0 application extension (loop forever)
+//	per frame:
+//	  graphic control extension (the delay)
+//	  image descriptor + local color table
+//	  LZW data in sub-blocks
+//	trailer (0x3B)
+type gifAnimator struct {
+	f      *os.File
+	w      *bufio.Writer
+	delay  int
+	frames int
+}
+
+func newGIFAnimator(fileName string, fps int) (*gifAnimator, error) {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	// GIF delays are in 100ths of a second
+	delay := 100 / fps
+	if delay < 2 {
+		delay = 2 // Many viewers treat anything less as 10
+	}
+
+	return &gifAnimator{
+		f:     f,
+		w:     bufio.NewWriter(f),
+		delay: delay,
+	}, nil
+}
+
+// write writes little endian values
+func (ga *gifAnimator) write(vals ...interface{}) error {
+	for _, v := range vals {
+		var err error
+		switch tv := v.(type) {
+		case string:
+			_, err = io.WriteString(ga.w, tv)
+		case []byte:
+			_, err = ga.w.Write(tv)
+		default:
+			err = binary.Write(ga.w, binary.LittleEndian, v)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeHeader writes the header, screen descriptor and loop extension
+func (ga *gifAnimator) writeHeader(width int, height int) error {
+	return ga.write(
+		"GIF89a",
+		uint16(width), uint16(height),
+		uint8(0), // no global color table
+		uint8(0), // background color index
+		uint8(0), // pixel aspect ratio
+		[]byte{0x21, 0xFF, 11}, "NETSCAPE2.0",
+		[]byte{3, 1}, uint16(0), uint8(0), // loop count 0 is forever
+	)
+}
+
+// gifBlockWriter splits LZW data into sub-blocks of at most 255 bytes
+type gifBlockWriter struct {
+	w   io.Writer
+	buf [256]byte
+	n   int
+}
+
+func (bw *gifBlockWriter) Write(p []byte) (int, error) {
+	for i, c := range p {
+		bw.n++
+		bw.buf[bw.n] = c
+		if bw.n == 255 {
+			if err := bw.flush(); err != nil {
+				return i, err
+			}
+		}
+	}
+	return len(p), nil
+}
+
+func (bw *gifBlockWriter) flush() error {
+	if bw.n == 0 {
+		return nil
+	}
+	bw.buf[0] = uint8(bw.n)
+	_, err := bw.w.Write(bw.buf[:bw.n+1])
+	bw.n = 0
+	return err
+}
+
+func (ga *gifAnimator) AddFrame(img image.Image) error {
+	b := img.Bounds()
+	if ga.frames == 0 {
+		if err := ga.writeHeader(b.Dx(), b.Dy()); err != nil {
+			return err
+		}
+	}
+	ga.frames++
+
+	pal := quantize(img, 256)
+	pimg := image.NewPaletted(b, pal)
+	draw.FloydSteinberg.Draw(pimg, b, img, b.Min)
+
+	// The color table size is 2^(bits+1) entries
+	bits := 0
+	for 1<<uint(bits+1) < len(pal) {
+		bits++
+	}
+	table := make([]byte, 3<<uint(bits+1))
+	for i, c := range pal {
+		r, g, bl, _ := c.RGBA()
+		table[3*i], table[3*i+1], table[3*i+2] = uint8(r>>8), uint8(g>>8), uint8(bl>>8)
+	}
+
+	if err := ga.write(
+		[]byte{0x21, 0xF9, 4},
+		uint8(0), // no disposal or transparency
+		uint16(ga.delay),
+		uint8(0), // transparent color index (unused)
+		uint8(0), // block terminator
+		uint8(0x2C),
+		uint16(0), uint16(0), uint16(b.Dx()), uint16(b.Dy()),
+		uint8(0x80|bits), // local color table
+		table,
+	); err != nil {
+		return err
+	}
+
+	// LZW needs a code size of at least 2
+	litWidth := bits + 1
+	if litWidth < 2 {
+		litWidth = 2
+	}
+	if err := ga.write(uint8(litWidth)); err != nil {
+		return err
+	}
+	bw := &gifBlockWriter{w: ga.w}
+	lw := lzw.NewWriter(bw, lzw.LSB, litWidth)
+	for y := 0; y < b.Dy(); y++ {
+		row := pimg.Pix[y*pimg.Stride : y*pimg.Stride+b.Dx()]
+		if _, err := lw.Write(row); err != nil {
+			lw.Close()
+			return err
+		}
+	}
+	if err := lw.Close(); err != nil {
+		return err
+	}
+	if err := bw.flush(); err != nil {
+		return err
+	}
+	return ga.write(uint8(0)) // end of the sub-blocks
+}
+
+func (ga *gifAnimator) Close() error {
+	var err error
+	if ga.frames > 0 {
+		err = ga.write(uint8(0x3B))
+	}
+	if ferr := ga.w.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := ga.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
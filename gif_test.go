@@ -0,0 +1,108 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// quadrantFrame is four flat colors that change with i, over a gradient
+// stripe along the bottom so the palette fills up
+func quadrantFrame(w, h, i int) (*image.NRGBA, [4]color.NRGBA) {
+	quads := [4]color.NRGBA{
+		{R: uint8(40 * i), G: 0, B: 0, A: 255},
+		{R: 0, G: uint8(255 - 40*i), B: 0, A: 255},
+		{R: 0, G: 0, B: uint8(30 + 40*i), A: 255},
+		{R: 200, G: 200, B: uint8(10 * i), A: 255},
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			q := 0
+			if x >= w/2 {
+				q++
+			}
+			if y >= h/2 {
+				q += 2
+			}
+			img.SetNRGBA(x, y, quads[q])
+			if y >= h-4 {
+				img.SetNRGBA(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: uint8(x + i), A: 255})
+			}
+		}
+	}
+	return img, quads
+}
+
+func TestGIFAnimator(t *testing.T) {
+	// Big enough for many LZW sub-blocks and for quantize to sample
+	const w, h, frames = 300, 200, 5
+	fileName := filepath.Join(t.TempDir(), "anim.gif")
+
+	ga, err := newGIFAnimator(fileName, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var quads [][4]color.NRGBA
+	for i := 0; i < frames; i++ {
+		img, q := quadrantFrame(w, h, i)
+		quads = append(quads, q)
+		if err := ga.AddFrame(img); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ga.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	anim, err := gif.DecodeAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(anim.Image) != frames {
+		t.Fatalf("Decoded %d frames, expected %d", len(anim.Image), frames)
+	}
+	if anim.LoopCount != 0 {
+		t.Errorf("Loop count is %d, expected 0 (forever)", anim.LoopCount)
+	}
+	if anim.Config.Width != w || anim.Config.Height != h {
+		t.Errorf("Screen is %dx%d, expected %dx%d", anim.Config.Width, anim.Config.Height, w, h)
+	}
+	for i, frame := range anim.Image {
+		if anim.Delay[i] != 10 {
+			t.Errorf("Frame %d has a delay of %d, expected 10", i, anim.Delay[i])
+		}
+		if frame.Bounds() != image.Rect(0, 0, w, h) {
+			t.Errorf("Frame %d has bounds %v", i, frame.Bounds())
+		}
+
+		// The flat quadrants come through exactly (away from the dithered
+		// edges)
+		for q, pt := range []image.Point{{w / 4, h / 4}, {3 * w / 4, h / 4}, {w / 4, h/2 + 20}, {3 * w / 4, h/2 + 20}} {
+			got := color.NRGBAModel.Convert(frame.At(pt.X, pt.Y)).(color.NRGBA)
+			if got != quads[i][q] {
+				t.Errorf("Frame %d quadrant %d is %v, expected %v", i, q, got, quads[i][q])
+			}
+		}
+	}
+}
+
+func TestGIFAnimatorEmpty(t *testing.T) {
+	// Closing without any frames is not an error
+	ga, err := newGIFAnimator(filepath.Join(t.TempDir(), "empty.gif"), 24)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ga.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
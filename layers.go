@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/CraigKelly/evoimage/evo"
+)
+
+// Layer separation: every palette color of an indexed color genome becomes
+// its own stencil (SVG and PNG) for screen printing or cutting. A layer is
+// everything that color covers in the final image, after later genes cover
+// earlier ones. Every layer has the same registration marks so that they can
+// be lined up.
+
+// layerInfo describes one exported layer
+type layerInfo struct {
+	Index  int
+	Color  color.NRGBA
+	Pixels int // Stencil pixels covered
+	Name   string
+}
+
+// layerOwners rasterizes a genome at scale stencil pixels per target pixel
+// (without anti-aliasing) and returns the palette index that ends up on top
+// of each stencil pixel. The background is palette index 0.
+func layerOwners(gj evo.GenomeJSON, w, h, scale int) []int {
+	sw, sh := w*scale, h*scale
+	owners := make([]int, sw*sh)
+
+	for _, g := range gj.Genes {
+		pts := make([][2]float64, len(g.Vertices))
+		minX, minY := math.Inf(1), math.Inf(1)
+		maxX, maxY := math.Inf(-1), math.Inf(-1)
+		for i, v := range g.Vertices {
+			pts[i] = [2]float64{float64(v[0]), float64(v[1])}
+			minX, maxX = math.Min(minX, pts[i][0]), math.Max(maxX, pts[i][0])
+			minY, maxY = math.Min(minY, pts[i][1]), math.Max(maxY, pts[i][1])
+		}
+
+		x0 := int(math.Max(0, math.Floor(minX*float64(scale))))
+		x1 := int(math.Min(float64(sw), math.Ceil(maxX*float64(scale))))
+		y0 := int(math.Max(0, math.Floor(minY*float64(scale))))
+		y1 := int(math.Min(float64(sh), math.Ceil(maxY*float64(scale))))
+		for sy := y0; sy < y1; sy++ {
+			py := (float64(sy) + 0.5) / float64(scale)
+			for sx := x0; sx < x1; sx++ {
+				px := (float64(sx) + 0.5) / float64(scale)
+				if insidePolygon(pts, px, py) {
+					owners[sy*sw+sx] = g.Index
+				}
+			}
+		}
+	}
+	return owners
+}
+
+// insidePolygon is the even-odd (crossing number) point in polygon test
+func insidePolygon(pts [][2]float64, x, y float64) bool {
+	inside := false
+	for i, j := 0, len(pts)-1; i < len(pts); j, i = i, i+1 {
+		xi, yi := pts[i][0], pts[i][1]
+		xj, yj := pts[j][0], pts[j][1]
+		if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// layerOutlines traces the outlines of the pixels in a mask as closed loops
+// of corner points. Outer edges go clockwise and holes counter-clockwise, so
+// the loops can be filled with either fill rule.
+func layerOutlines(mask []bool, w, h int) [][][2]int {
+	on := func(x, y int) bool {
+		return x >= 0 && y >= 0 && x < w && y < h && mask[y*w+x]
+	}
+
+	// Every boundary edge of the mask, going clockwise around its pixel,
+	// indexed by start corner
+	type edge struct{ from, to [2]int }
+	out := make(map[[2]int][]int)
+	var edges []edge
+	add := func(x0, y0, x1, y1 int) {
+		out[[2]int{x0, y0}] = append(out[[2]int{x0, y0}], len(edges))
+		edges = append(edges, edge{[2]int{x0, y0}, [2]int{x1, y1}})
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if !mask[y*w+x] {
+				continue
+			}
+			if !on(x, y-1) {
+				add(x, y, x+1, y)
+			}
+			if !on(x+1, y) {
+				add(x+1, y, x+1, y+1)
+			}
+			if !on(x, y+1) {
+				add(x+1, y+1, x, y+1)
+			}
+			if !on(x-1, y) {
+				add(x, y+1, x, y)
+			}
+		}
+	}
+
+	dir := func(e edge) [2]int { return [2]int{e.to[0] - e.from[0], e.to[1] - e.from[1]} }
+	used := make([]bool, len(edges))
+	var loops [][][2]int
+	for start := range edges {
+		if used[start] {
+			continue
+		}
+		var loop [][2]int
+		cur := start
+		for !used[cur] {
+			used[cur] = true
+			e := edges[cur]
+			loop = append(loop, e.from)
+
+			// Where two pixels only touch at a corner there are two ways
+			// on: turn right, which keeps the pixels apart. We can only
+			// go back to an edge we've used if it closes the loop.
+			next := -1
+			for _, cand := range out[e.to] {
+				if used[cand] && cand != start {
+					continue
+				}
+				if next < 0 {
+					next = cand
+					continue
+				}
+				d, c := dir(e), dir(edges[cand])
+				if d[0]*c[1]-d[1]*c[0] > 0 { // Right turn (y is down)
+					next = cand
+				}
+			}
+			if next < 0 || next == start {
+				break
+			}
+			cur = next
+		}
+		loops = append(loops, simplifyLoop(loop))
+	}
+	return loops
+}
+
+// simplifyLoop drops the corners where a loop goes straight on
+func simplifyLoop(loop [][2]int) [][2]int {
+	n := len(loop)
+	if n < 3 {
+		return loop
+	}
+	var out [][2]int
+	for i, p := range loop {
+		prev, next := loop[(i+n-1)%n], loop[(i+1)%n]
+		d1 := [2]int{p[0] - prev[0], p[1] - prev[1]}
+		d2 := [2]int{next[0] - p[0], next[1] - p[1]}
+		if d1[0]*d2[1]-d1[1]*d2[0] != 0 {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// svgLayerPath returns the SVG path data for the loops, offset by the margin
+func svgLayerPath(loops [][][2]int, margin int) string {
+	var sb strings.Builder
+	for _, loop := range loops {
+		for i, p := range loop {
+			cmd := "L"
+			if i == 0 {
+				cmd = "M"
+			}
+			fmt.Fprintf(&sb, "%s%d %d", cmd, p[0]+margin, p[1]+margin)
+		}
+		sb.WriteString("Z")
+	}
+	return sb.String()
+}
+
+// registrationMarks returns the centers of the marks: one in the middle of
+// each corner of the margin
+func registrationMarks(w, h, margin int) [][2]int {
+	m := margin / 2
+	return [][2]int{{m, m}, {w + margin + m, m}, {m, h + margin + m}, {w + margin + m, h + margin + m}}
+}
+
+// markSize returns the circle radius, cross half length and line width of a
+// registration mark
+func markSize(margin int) (radius, cross, width float64) {
+	radius = float64(margin) * 0.25
+	cross = float64(margin) * 0.4
+	width = math.Max(1.0, float64(margin)*0.04)
+	return
+}
+
+// writeLayerSVG writes filled loops with registration marks. Each layer is
+// (fill color, path data).
+func writeLayerSVG(fileName string, w, h, margin int, layers [][2]string) error {
+	tw, th := w+2*margin, h+2*margin
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\">\n", tw, th, tw, th)
+	fmt.Fprintf(&buf, "<rect x=\"0\" y=\"0\" width=\"%d\" height=\"%d\" fill=\"#ffffff\"/>\n", tw, th)
+	for _, layer := range layers {
+		fmt.Fprintf(&buf, "<path fill=\"%s\" fill-rule=\"evenodd\" d=\"%s\"/>\n", layer[0], layer[1])
+	}
+
+	radius, cross, width := markSize(margin)
+	for _, c := range registrationMarks(w, h, margin) {
+		fmt.Fprintf(&buf, "<g fill=\"none\" stroke=\"#000000\" stroke-width=\"%s\">", svgNum(width))
+		fmt.Fprintf(&buf, "<circle cx=\"%d\" cy=\"%d\" r=\"%s\"/>", c[0], c[1], svgNum(radius))
+		fmt.Fprintf(&buf, "<path d=\"M%s %dH%sM%d %sV%s\"/></g>\n",
+			svgNum(float64(c[0])-cross), c[1], svgNum(float64(c[0])+cross),
+			c[0], svgNum(float64(c[1])-cross), svgNum(float64(c[1])+cross))
+	}
+	buf.WriteString("</svg>\n")
+	return ioutil.WriteFile(fileName, buf.Bytes(), 0644)
+}
+
+// writeLayerPNG writes a black on white stencil with registration marks
+func writeLayerPNG(fileName string, mask []bool, w, h, margin int) error {
+	img := image.NewGray(image.Rect(0, 0, w+2*margin, h+2*margin))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if mask[y*w+x] {
+				img.Pix[(y+margin)*img.Stride+x+margin] = 0
+			}
+		}
+	}
+
+	radius, cross, width := markSize(margin)
+	half := width / 2.0
+	for _, c := range registrationMarks(w, h, margin) {
+		for y := c[1] - int(cross) - 1; y <= c[1]+int(cross)+1; y++ {
+			for x := c[0] - int(cross) - 1; x <= c[0]+int(cross)+1; x++ {
+				dx, dy := float64(x)+0.5-float64(c[0]), float64(y)+0.5-float64(c[1])
+				onCircle := math.Abs(math.Hypot(dx, dy)-radius) <= half
+				onCross := (math.Abs(dx) <= half && math.Abs(dy) <= cross) || (math.Abs(dy) <= half && math.Abs(dx) <= cross)
+				if (onCircle || onCross) && image.Pt(x, y).In(img.Bounds()) {
+					img.SetGray(x, y, color.Gray{Y: 0})
+				}
+			}
+		}
+	}
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// hexColor returns a color as rrggbb
+func hexColor(c color.NRGBA) string {
+	return fmt.Sprintf("%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// writeLayers exports every palette color of an indexed color genome (for a
+// w x h target) to outDir: layer-NN-rrggbb.svg and .png stencils, plus
+// composite.svg with every layer in its color. Colors that don't show up in
+// the image are skipped.
+func writeLayers(outDir string, gj evo.GenomeJSON, w, h, scale int, writeSVG, writePNG bool) ([]layerInfo, error) {
+	if len(gj.Palette) < 1 {
+		return nil, errors.New("Genome has no palette: layers need an indexed color genome (see -palette)")
+	}
+	if scale < 1 {
+		return nil, errors.New("Layer scale must be >= 1")
+	}
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return nil, err
+	}
+
+	sw, sh := w*scale, h*scale
+	margin := int(math.Max(12.0, math.Round(float64(sw+sh)*0.04)))
+	owners := layerOwners(gj, w, h, scale)
+
+	var infos []layerInfo
+	var composite [][2]string
+	for idx, pc := range gj.Palette {
+		clr := color.NRGBA{R: pc[0], G: pc[1], B: pc[2], A: pc[3]}
+		mask := make([]bool, len(owners))
+		count := 0
+		for i, owner := range owners {
+			if owner == idx {
+				mask[i] = true
+				count++
+			}
+		}
+		if count < 1 {
+			continue
+		}
+
+		info := layerInfo{Index: idx, Color: clr, Pixels: count, Name: fmt.Sprintf("layer-%02d-%s", idx, hexColor(clr))}
+		infos = append(infos, info)
+
+		if writeSVG {
+			path := svgLayerPath(layerOutlines(mask, sw, sh), margin)
+			composite = append(composite, [2]string{"#" + hexColor(clr), path})
+			if err := writeLayerSVG(filepath.Join(outDir, info.Name+".svg"), sw, sh, margin, [][2]string{{"#000000", path}}); err != nil {
+				return nil, err
+			}
+		}
+		if writePNG {
+			if err := writeLayerPNG(filepath.Join(outDir, info.Name+".png"), mask, sw, sh, margin); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if writeSVG {
+		if err := writeLayerSVG(filepath.Join(outDir, "composite.svg"), sw, sh, margin, composite); err != nil {
+			return nil, err
+		}
+	}
+	return infos, nil
+}
+
+// loadLayerSource finds the genome and target for the layers command: src is
+// a run directory (using final-genome.json and the manifest's target) or a
+// genome JSON file
+func loadLayerSource(src string, targetName string) (evo.GenomeJSON, *evo.ImageTarget, error) {
+	var gj evo.GenomeJSON
+	genomeName, runDir := src, filepath.Dir(src)
+	if st, err := os.Stat(src); err != nil {
+		return gj, nil, err
+	} else if st.IsDir() {
+		genomeName, runDir = filepath.Join(src, "final-genome.json"), src
+	}
+
+	data, err := ioutil.ReadFile(genomeName)
+	if err != nil {
+		return gj, nil, err
+	}
+	if err := json.Unmarshal(data, &gj); err != nil {
+		return gj, nil, err
+	}
+
+	if len(targetName) < 1 {
+		m, err := LoadManifest(filepath.Join(runDir, "manifest.json"))
+		if err != nil {
+			return gj, nil, fmt.Errorf("No target image (use -image): %v", err)
+		}
+		targetName = m.Target
+	}
+	target, err := evo.NewImageTarget(targetName)
+	return gj, target, err
+}
+
+// printLayers lists the exported layers
+func printLayers(outDir string, infos []layerInfo) {
+	for _, info := range infos {
+		fmt.Printf("%s  #%s  %d pixels\n", info.Name, hexColor(info.Color), info.Pixels)
+	}
+	fmt.Printf("Wrote %d layers to %s\n", len(infos), outDir)
+}
+
+// layersMain implements the layers command
+func layersMain(args []string) {
+	flags := flag.NewFlagSet("evoimage layers", flag.ExitOnError)
+	outDir := flags.String("out", "", "Output directory (default is layers in the run directory)")
+	targetName := flags.String("image", "", "Target image (default is the target in the run's manifest)")
+	scale := flags.Int("scale", 4, "Stencil pixels per target pixel")
+	writeSVG := flags.Bool("svg", true, "Write SVG layers and composite.svg")
+	writePNG := flags.Bool("png", true, "Write PNG stencils")
+	flags.Usage = func() {
+		log.Printf("Usage: evoimage layers [options] run_dir|genome.json\n")
+		flags.PrintDefaults()
+	}
+
+	pcheck(flags.Parse(args))
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	gj, target, err := loadLayerSource(flags.Arg(0), *targetName)
+	pcheck(err)
+	if len(*outDir) < 1 {
+		dir := flags.Arg(0)
+		if st, err := os.Stat(dir); err == nil && !st.IsDir() {
+			dir = filepath.Dir(dir)
+		}
+		*outDir = filepath.Join(dir, "layers")
+	}
+
+	b := target.Image().Bounds()
+	infos, err := writeLayers(*outDir, gj, b.Dx(), b.Dy(), *scale, *writeSVG, *writePNG)
+	pcheck(err)
+	printLayers(*outDir, infos)
+}
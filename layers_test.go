@@ -0,0 +1,133 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/CraigKelly/evoimage/evo"
+)
+
+// testMask makes a w x h mask from rows of '#' (on) and '.' (off)
+func testMask(rows ...string) ([]bool, int, int) {
+	w, h := len(rows[0]), len(rows)
+	mask := make([]bool, w*h)
+	for y, row := range rows {
+		for x, c := range row {
+			mask[y*w+x] = c == '#'
+		}
+	}
+	return mask, w, h
+}
+
+// loopArea is twice the signed area of a loop: positive for clockwise (with
+// y down) and negative for counter-clockwise
+func loopArea(loop [][2]int) int {
+	area := 0
+	for i, p := range loop {
+		q := loop[(i+1)%len(loop)]
+		area += p[0]*q[1] - q[0]*p[1]
+	}
+	return area
+}
+
+func TestLayerOutlinesSquare(t *testing.T) {
+	mask, w, h := testMask(
+		"....",
+		".##.",
+		".##.",
+		"....",
+	)
+	loops := layerOutlines(mask, w, h)
+	if len(loops) != 1 {
+		t.Fatalf("Expected 1 loop, got %v", loops)
+	}
+
+	// Just the 4 corners (the straight runs are simplified away), clockwise
+	exp := [][2]int{{1, 1}, {3, 1}, {3, 3}, {1, 3}}
+	if len(loops[0]) != len(exp) {
+		t.Fatalf("Loop is %v, expected %v", loops[0], exp)
+	}
+	for i := range exp {
+		if loops[0][i] != exp[i] {
+			t.Fatalf("Loop is %v, expected %v", loops[0], exp)
+		}
+	}
+	if a := loopArea(loops[0]); a != 8 {
+		t.Errorf("Square loop has a signed area of %d/2, expected 8/2", a)
+	}
+}
+
+func TestLayerOutlinesHole(t *testing.T) {
+	mask, w, h := testMask(
+		".....",
+		".###.",
+		".#.#.",
+		".###.",
+		".....",
+	)
+	loops := layerOutlines(mask, w, h)
+	if len(loops) != 2 {
+		t.Fatalf("Expected an outer loop and a hole, got %v", loops)
+	}
+
+	// The outer edge is clockwise around 9 pixels and the hole is
+	// counter-clockwise around 1
+	areas := map[int]bool{}
+	for _, loop := range loops {
+		areas[loopArea(loop)] = true
+	}
+	if !areas[18] || !areas[-2] {
+		t.Errorf("Expected signed areas 18/2 and -2/2, got loops %v", loops)
+	}
+}
+
+func TestLayerOutlinesDiagonal(t *testing.T) {
+	// Pixels that only touch at a corner stay separate shapes
+	mask, w, h := testMask(
+		"#.",
+		".#",
+	)
+	loops := layerOutlines(mask, w, h)
+	if len(loops) != 2 {
+		t.Fatalf("Expected 2 loops, got %v", loops)
+	}
+	for _, loop := range loops {
+		if len(loop) != 4 || loopArea(loop) != 2 {
+			t.Errorf("Expected a clockwise single pixel loop, got %v", loop)
+		}
+	}
+}
+
+func TestLayerOwners(t *testing.T) {
+	gj := evo.GenomeJSON{Genes: []evo.GeneJSON{
+		{Vertices: [][2]int{{0, 0}, {4, 0}, {0, 4}}, Index: 1},
+		{Vertices: [][2]int{{0, 0}, {2, 0}, {0, 2}}, Index: 2},
+	}}
+
+	for _, scale := range []int{1, 2} {
+		owners := layerOwners(gj, 4, 4, scale)
+		if len(owners) != 16*scale*scale {
+			t.Fatalf("Scale %d: %d owners for a 4x4 genome", scale, len(owners))
+		}
+		at := func(x, y int) int {
+			return owners[(y*scale)*4*scale+x*scale]
+		}
+
+		// The later gene hides the earlier one, the rest of the earlier gene
+		// shows, and the background is index 0
+		if o := at(0, 0); o != 2 {
+			t.Errorf("Scale %d: pixel (0,0) is owned by %d, expected 2 (on top)", scale, o)
+		}
+		if o := at(2, 0); o != 1 {
+			t.Errorf("Scale %d: pixel (2,0) is owned by %d, expected 1", scale, o)
+		}
+		if o := at(3, 3); o != 0 {
+			t.Errorf("Scale %d: pixel (3,3) is owned by %d, expected the background", scale, o)
+		}
+	}
+
+	// Reversing the genes puts the big triangle on top
+	gj.Genes[0], gj.Genes[1] = gj.Genes[1], gj.Genes[0]
+	if o := layerOwners(gj, 4, 4, 1)[0]; o != 1 {
+		t.Errorf("Pixel (0,0) is owned by %d after the big triangle, expected 1", o)
+	}
+}
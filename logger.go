@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/CraigKelly/evoimage/evo"
+)
+
+// csvLog writes CSV rows from a dedicated goroutine so that the main loop
+// never waits on the disk. Rows are flushed whenever the queue empties.
+type csvLog struct {
+	rows chan []string
+	done chan error
+}
+
+// newCSVLog opens (or creates) the given file for appending and starts the
+// writer goroutine
+func newCSVLog(fileName string) (*csvLog, error) {
+	f, err := os.OpenFile(fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &csvLog{
+		rows: make(chan []string, 1024),
+		done: make(chan error, 1),
+	}
+
+	go func() {
+		w := csv.NewWriter(f)
+		var werr error
+		for row := range l.rows {
+			if werr != nil {
+				continue // keep draining so Write never blocks forever
+			}
+			werr = w.Write(row)
+			if werr == nil && len(l.rows) == 0 {
+				w.Flush()
+				werr = w.Error()
+			}
+		}
+
+		w.Flush()
+		if werr == nil {
+			werr = w.Error()
+		}
+		if cerr := f.Close(); werr == nil {
+			werr = cerr
+		}
+		l.done <- werr
+	}()
+
+	return l, nil
+}
+
+// Write queues a row for writing
+func (l *csvLog) Write(row []string) {
+	l.rows <- row
+}
+
+// Close flushes all queued rows, closes the file, and returns the first error
+// encountered by the writer
+func (l *csvLog) Close() error {
+	close(l.rows)
+	return <-l.done
+}
+
+// genLogger is the observer that logs every generation: a row in the CSV log
+// and a line on the console
+type genLogger struct {
+	csv *csvLog
+}
+
+// newGenLogger opens the CSV log and writes the title line
+func newGenLogger(fileName string) (*genLogger, error) {
+	l, err := newCSVLog(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Always write a title line - that way we can detect restarts
+	l.Write([]string{
+		"Gen", "Best", "Worst", "Avg",
+		"PopSize", "TournSize", "MutRate", "StallCount", "Evals", "EvalSecs",
+		"MeanDist", "BestDist", "PixelBestDist", "Unique",
+		"AreaMean", "AreaMin", "AreaMax", "Invisible",
+		"Timestamp",
+	})
+	return &genLogger{csv: l}, nil
+}
+
+func (gl *genLogger) Observe(gen *evo.Generation) error {
+	gl.csv.Write([]string{
+		fmt.Sprintf("%d", gen.Gen),
+		fmt.Sprintf("%.5f", gen.Best),
+		fmt.Sprintf("%.5f", gen.Worst),
+		fmt.Sprintf("%.5f", gen.Avg),
+		fmt.Sprintf("%d", gen.PopSize),
+		fmt.Sprintf("%d", gen.TournSize),
+		fmt.Sprintf("%.5f", gen.MutRate),
+		fmt.Sprintf("%d", gen.StallCount),
+		fmt.Sprintf("%d", gen.Evals),
+		fmt.Sprintf("%.3f", gen.EvalTime.Seconds()),
+		fmt.Sprintf("%.5f", gen.Diversity.MeanDist),
+		fmt.Sprintf("%.5f", gen.Diversity.BestDist),
+		fmt.Sprintf("%.5f", gen.Diversity.PixelBestDist),
+		fmt.Sprintf("%d", gen.Diversity.Unique),
+		fmt.Sprintf("%.1f", gen.Genes.AreaMean),
+		fmt.Sprintf("%.1f", gen.Genes.AreaMin),
+		fmt.Sprintf("%.1f", gen.Genes.AreaMax),
+		fmt.Sprintf("%d", gen.Genes.Invisible),
+		time.Now().Format("2006-01-02 15:04:05"),
+	})
+
+	log.Printf(
+		"Gen:%5d PS:%5d SC:%d,TS:%d,MR:%.5f,DV:%.4f,UQ:%d best %.2f <=> avg %.2f <=> worst %.2f\n",
+		gen.Gen, gen.PopSize,
+		gen.StallCount, gen.TournSize, gen.MutRate, gen.Diversity.MeanDist, gen.Diversity.Unique,
+		gen.Best, gen.Avg, gen.Worst,
+	)
+	if gen.Immigrants > 0 {
+		log.Printf("Diversity %.4f: injected %d immigrants\n", gen.Diversity.MeanDist, gen.Immigrants)
+	}
+	return nil
+}
+
+// Close flushes and closes the CSV log
+func (gl *genLogger) Close() error {
+	return gl.csv.Close()
+}
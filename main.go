@@ -1,22 +1,22 @@
 package main
 
 import (
-	"encoding/csv"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
-	"math"
 	"math/rand"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"runtime"
-	"sort"
-	"sync"
+	"strings"
+	"syscall"
 	"time"
-)
 
-// TODO: unit tests
+	"github.com/CraigKelly/evoimage/evo"
+)
 
 // helper for checking errors
 func pcheck(err error) {
@@ -25,54 +25,113 @@ func pcheck(err error) {
 	}
 }
 
-// multi-core evaluation
-func evalPop(pop Population, cores int) {
-	wait := sync.WaitGroup{}
-	wait.Add(cores)
-
-	work := make(chan int, 256)
-
-	for c := 0; c < cores; c++ {
-		go func() {
-			defer wait.Done()
-			for idx := range work {
-				pop[idx].Fitness()
-			}
-		}()
-	}
-
-	for i := range pop {
-		work <- i
-	}
-	close(work)
-
-	wait.Wait()
-}
-
 /////////////////////////////////////////////////////////////////////////////
 // Entry point
 
 func main() {
+	// Commands other than evolving an image
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "animate":
+			animateMain(os.Args[2:])
+			return
+		case "sweep":
+			sweepMain(os.Args[2:])
+			return
+		case "compare":
+			compareMain(os.Args[2:])
+			return
+		case "plot":
+			plotMain(os.Args[2:])
+			return
+		case "bench":
+			benchMain(os.Args[2:])
+			return
+		case "layers":
+			layersMain(os.Args[2:])
+			return
+		}
+	}
+
+	// Output-only flags (that don't change the result) go in outputFlags too
+	def := evo.DefaultOptions()
 	flags := flag.NewFlagSet("evoimage", flag.ExitOnError)
-	mutationRate := flags.Float64("mutationRate", 0.11, "Mutation rate to use")
-	crossOverRate := flags.Float64("crossoverRate", 0.60, "Crossover rate to use")
-	popSize := flags.Int("popSize", 300, "Population size in a generation")
+	mutationRate := flags.Float64("mutationRate", def.MutationRate, "Mutation rate to use")
+	crossOverRate := flags.Float64("crossoverRate", def.CrossoverRate, "Crossover rate to use")
+	popSize := flags.Int("popSize", def.PopSize, "Population size in a generation")
 	image := flags.String("image", "", "File name of target image")
-	geneCount := flags.Int("geneCount", 100, "Number of genes (triangles) in an individual")
+	geneCount := flags.Int("geneCount", def.GeneCount, "Number of genes (triangles) in an individual")
+	sharingRadius := flags.Float64("sharingRadius", def.SharingRadius, "Fitness sharing niche radius in genome distance (0 disables sharing)")
+	crowding := flags.Bool("crowding", def.Crowding, "Use deterministic crowding replacement instead of elitism")
+	immigrantThreshold := flags.Float64("immigrantThreshold", def.ImmigrantThreshold, "Inject random immigrants when diversity falls below this (0 disables)")
+	immigrantRate := flags.Float64("immigrantRate", def.ImmigrantRate, "Fraction of the population replaced by random immigrants")
+	diversitySample := flags.Int("diversitySample", def.DiversitySample, "Max individuals sampled when measuring diversity")
+	weights := flags.String("weights", "", "Per pixel fitness weights: a grayscale mask image, saliency or center (default is unweighted)")
+	gradientName := flags.String("gradient", "", "Add an edge alignment term to fitness using this gradient operator: sobel or scharr")
+	gradientWeight := flags.Float64("gradientWeight", 0.25, "Weight of the gradient term (the color term gets the rest)")
+	paletteSize := flags.Int("palette", 0, "Use indexed colors: genes pick from a palette of this many colors (0 for free colors)")
+	layers := flags.Bool("layers", false, "Export each palette color of the final genome as SVG and PNG stencils (needs -palette)")
+	evolvePalette := flags.Bool("evolvePalette", false, "Evolve the palette with the genome (default keeps the k-means palette fixed)")
+	initDef := evo.DefaultInitOptions()
+	initColor := flags.String("initColor", initDef.Color, "Initial gene colors: random, or target (the target color under the triangle)")
+	initSize := flags.String("initSize", initDef.Size, "Initial triangle size: canvas (vertices anywhere), or a radius from fixed, normal or lognormal")
+	initSizeMean := flags.Float64("initSizeMean", initDef.SizeMean, "Mean initial triangle radius as a fraction of the larger image side")
+	initSizeSD := flags.Float64("initSizeSD", initDef.SizeSD, "Initial radius standard deviation (fraction of the larger side for normal, log space for lognormal)")
+	initAlphaMin := flags.Int("initAlphaMin", int(initDef.AlphaMin), "Minimum initial gene alpha (0-255, the default of 0 to 0 starts genes invisible)")
+	initAlphaMax := flags.Int("initAlphaMax", int(initDef.AlphaMax), "Maximum initial gene alpha (0-255, 0 keeps new genes invisible)")
+	initPlacement := flags.String("initPlacement", initDef.Placement, "Initial triangle placement: uniform, or variance (favor busy regions of the target)")
+	distanceName := flags.String("distance", "genome", "Distance used for sharing and crowding: genome, vertex, color or pixel")
+	seed := flags.Int64("seed", 0, "Random seed (0 uses the current time)")
+	runBase := flags.String("runDir", "runs", "Directory where each run gets its own output directory")
+	runID := flags.String("runID", "", "Run ID used to name the run directory (default is time, target and seed)")
+	snapshot := flags.String("snapshot", "improve", "Which generations get their best image saved: all, improve, every or log")
+	snapshotEvery := flags.Int("snapshotEvery", 100, "Generations between snapshots for the every policy")
+	snapshotKeep := flags.Int("snapshotKeep", 0, "Only keep the last K snapshots (0 keeps them all)")
+	format := flags.String("format", "jpg", "Image format for snapshots: jpg or png (lossless)")
+	coreCount := flags.Int("cores", 0, "Number of cores used for evaluation (0 uses all of them)")
+	stallLimit := flags.Int("stallLimit", def.StallLimit, "Stop after this many generations without improvement")
+	maxGens := flags.Int("maxGens", def.MaxGenerations, "Stop after this many generations")
+	maxTime := flags.Duration("maxTime", 0, "Stop after this much wall clock time, e.g. 30m (0 for no limit)")
+	maxEvals := flags.Uint64("maxEvals", 0, "Stop after this many fitness evaluations (0 for no limit)")
+	targetFitness := flags.Float64("targetFitness", def.TargetFitness, "Stop when the best fitness is below this")
+	improveWindow := flags.Int("improveWindow", 0, "Stop if fitness improves by less than improveMin over this many generations (0 disables)")
+	improveMin := flags.Float64("improveMin", def.ImproveMin, "Minimum relative improvement over improveWindow (0.001 is 0.1%)")
+	controlSocket := flags.String("control", "", "Accept run control commands on this Unix socket")
+	resume := flags.String("resume", "", "Resume from a checkpoint file written by a previous run")
+	httpAddr := flags.String("http", "", "Serve a live dashboard on this address (e.g. :8080)")
+	animate := flags.String("animate", "", "Comma separated animation files (.gif or .avi) written live to the run directory")
+	fps := flags.Int("fps", 24, "Animation frames per second")
+	frameSkip := flags.Int("frameSkip", 1, "Only use every Nth generation in animations")
 
 	pcheck(flags.Parse(os.Args[1:]))
 
-	if *mutationRate <= 0.0 || *mutationRate >= 1.0 {
-		pcheck(errors.New("Invalid mutation rate - must be between 0 and 1"))
+	distance, ok := evo.NewDistanceFunc(*distanceName)
+	if !ok {
+		pcheck(errors.New("Invalid distance - must be genome, vertex, color or pixel"))
 	}
-	if *crossOverRate <= 0.0 || *crossOverRate >= 1.0 {
-		pcheck(errors.New("Invalid crossover rate - must be between 0 and 1"))
+	opts := evo.Options{
+		GeneCount:          *geneCount,
+		PopSize:            *popSize,
+		MutationRate:       *mutationRate,
+		CrossoverRate:      *crossOverRate,
+		SharingRadius:      *sharingRadius,
+		Crowding:           *crowding,
+		ImmigrantThreshold: *immigrantThreshold,
+		ImmigrantRate:      *immigrantRate,
+		DiversitySample:    *diversitySample,
+		Distance:           distance,
+		Cores:              *coreCount,
+		StallLimit:         *stallLimit,
+		MaxGenerations:     *maxGens,
+		MaxTime:            *maxTime,
+		MaxEvals:           *maxEvals,
+		TargetFitness:      *targetFitness,
+		ImproveWindow:      *improveWindow,
+		ImproveMin:         *improveMin,
 	}
-	if *popSize < 10 {
-		pcheck(errors.New("Invalid population size - must be at least 10"))
-	}
-	if *geneCount < 2 {
-		pcheck(errors.New("Gene Count must be >= 2"))
+	pcheck(opts.Validate())
+	if *layers && *paletteSize == 0 {
+		pcheck(errors.New("Layers need an indexed color genome (-palette)"))
 	}
 	if image == nil || len(*image) < 1 {
 		pcheck(errors.New("Image filename is required"))
@@ -82,148 +141,261 @@ func main() {
 	}
 
 	log.Printf("Genes:%d, Mutation:%f, Crossover:%f, Population:%d, Target:%s\n", *geneCount, *mutationRate, *crossOverRate, *popSize, *image)
+	log.Printf("Sharing:%f, Crowding:%v, Immigrants:%f@%f, Distance:%s\n", *sharingRadius, *crowding, *immigrantRate, *immigrantThreshold, *distanceName)
+
+	startTime := time.Now()
+	if *seed == 0 {
+		*seed = startTime.UnixNano()
+	}
+	rand.Seed(*seed)
 
-	rand.Seed(time.Now().UnixNano())
+	if len(*runID) < 1 {
+		*runID = newRunID(startTime, *image, *seed)
+	}
+	runDir := filepath.Join(*runBase, *runID)
+	log.Printf("Run %s with seed %d in %s\n", *runID, *seed, runDir)
+	pcheck(os.MkdirAll(runDir, 0755))
+
+	manifestFileName := filepath.Join(runDir, "manifest.json")
+	manifest, err := NewManifest(*runID, *image, *seed, flags)
+	pcheck(err)
+	pcheck(manifest.Save(manifestFileName))
 
 	log.Printf("Loading image %s\n", *image)
-	target, err := NewImageTarget(*image)
+	target, err := evo.NewImageTarget(*image)
 	pcheck(err)
-	target.ImageMode()
+	if len(*weights) > 0 {
+		log.Printf("Weighting fitness with %s\n", *weights)
+		w, err := loadWeights(*weights, target)
+		pcheck(err)
+		pcheck(target.SetWeights(w))
+		pcheck(saveWeightImage(filepath.Join(runDir, "weights.png"), target))
+	}
+	if len(*gradientName) > 0 {
+		op, ok := evo.NewGradientOperator(*gradientName)
+		if !ok {
+			pcheck(errors.New("Invalid gradient - must be sobel or scharr"))
+		}
+		log.Printf("Gradient term: %s with weight %f\n", op.Name, *gradientWeight)
+		pcheck(target.SetGradient(op, *gradientWeight))
+	}
+	if *paletteSize != 0 {
+		pcheck(target.SetPalette(*paletteSize, *evolvePalette))
+		log.Printf("Palette of %d colors (evolved:%v): %v\n", len(target.Palette()), *evolvePalette, target.Palette())
+	}
+	if *initAlphaMin < 0 || *initAlphaMin > 255 || *initAlphaMax < 0 || *initAlphaMax > 255 {
+		pcheck(errors.New("Initial alpha must be between 0 and 255"))
+	}
+	if *paletteSize != 0 && (*initAlphaMin != 0 || *initAlphaMax != 0) {
+		pcheck(errors.New("Initial alpha doesn't apply to indexed colors (-palette), which are always opaque"))
+	}
+	initOpts := evo.InitOptions{
+		Color:     *initColor,
+		Size:      *initSize,
+		SizeMean:  *initSizeMean,
+		SizeSD:    *initSizeSD,
+		AlphaMin:  uint8(*initAlphaMin),
+		AlphaMax:  uint8(*initAlphaMax),
+		Placement: *initPlacement,
+	}
+	pcheck(target.SetInit(initOpts))
+	if initOpts != initDef {
+		log.Printf("Init: %+v\n", initOpts)
+	}
 
-	_, imageBase := filepath.Split(*image)
-	logFileName := fmt.Sprintf("logs/%s-log.csv", imageBase)
+	logFileName := filepath.Join(runDir, "log.csv")
 	log.Printf("Opening log file %s\n", logFileName)
-	logf, err := os.OpenFile(logFileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	genLog, err := newGenLogger(logFileName)
 	pcheck(err)
-	dataLog := csv.NewWriter(logf)
-	defer dataLog.Flush()
-	defer logf.Close()
-	// Always write a title line - that way we can detect restarts
-	pcheck(dataLog.Write([]string{"Gen", "Best", "Worst", "Avg", "Timestamp"}))
-
-	log.Printf("Creating init pop of %d\n", *popSize)
-	population := Population(make([]*Individual, 0, *popSize))
-	for i := 0; i < *popSize; i++ {
-		ind := NewIndividual(target, *geneCount)
-		ind.RandInit()
-		population = append(population, ind)
-	}
-
-	cores := runtime.NumCPU()
-	if cores < 2 {
-		cores = 2
-	}
-	log.Printf("Working with %d cores\n", cores)
-
-	tournSize := 5
-	lastBest := float64(100.0)
-	stallCount := 0
-	adaptMutRate := *mutationRate
-	adaptPopSize := *popSize
-	maxMutRate := 1.30 * *mutationRate
-
-	for generation := 0; generation < 100000; generation++ {
-		// Additional stopping conditions
-		if stallCount > 100 {
-			fmt.Printf("Stall count == %d, stopping\n", stallCount)
-			break
-		}
-		if lastBest < 0.5 {
-			// This one will probaby never happen (99.5% of optimal)
-			fmt.Printf("Best fitness == %f, stopping\n", lastBest)
-			break
+
+	snapshots, err := newSnapshotWriter(runDir, *snapshot, *format, *snapshotEvery, *snapshotKeep)
+	pcheck(err)
+
+	var animators []Animator
+	for _, animName := range strings.Split(*animate, ",") {
+		if animName = strings.TrimSpace(animName); len(animName) < 1 {
+			continue
 		}
+		anim, err := NewAnimator(filepath.Join(runDir, animName), *fps, *frameSkip)
+		pcheck(err)
+		animators = append(animators, anim)
+	}
 
-		// Image creation and evaluation across all cores
-		evalPop(population, cores)
+	eventLog, err := newCSVLog(filepath.Join(runDir, "events.csv"))
+	pcheck(err)
+	eventLog.Write([]string{"Gen", "Event", "Timestamp"})
+	logEvent := func(generation int, event string) {
+		log.Printf("Gen:%5d %s\n", generation, event)
+		eventLog.Write([]string{
+			fmt.Sprintf("%d", generation),
+			event,
+			time.Now().Format("2006-01-02 15:04:05"),
+		})
+	}
 
-		// Now we can sort and find best/worst
-		sort.Sort(population)
-		best := population[0].Fitness()
-		worst := population[len(population)-1].Fitness()
-		avg := population.MeanFitness()
+	ctl := newController()
+
+	// Ctrl-C (or a kill) finishes the current generation and then shuts down
+	// cleanly. A second signal exits immediately.
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		log.Printf("Received %v: stopping after this generation (again to exit now)\n", sig)
+		ctl.queue(controlCmd{Name: "stop"})
+		sig = <-sigs
+		log.Printf("Received %v: exiting immediately\n", sig)
+		os.Exit(1)
+	}()
+	var dash *dashboard
+	var prom *metrics
+	if len(*httpAddr) > 0 {
+		mux := http.NewServeMux()
+		dash = newDashboard(*runID, target)
+		dash.Handle(mux)
+		prom = newMetrics(*runID, target)
+		prom.Handle(mux)
+		ctl.Handle(mux)
+		pcheck(startHTTP(*httpAddr, mux))
+	}
+	if len(*controlSocket) > 0 {
+		mux := http.NewServeMux()
+		ctl.Handle(mux)
+		pcheck(startControlSocket(*controlSocket, mux))
+		defer os.Remove(*controlSocket)
+	}
 
-		if math.Abs(best-lastBest) < 0.0000001 {
-			stallCount++
-		} else {
-			stallCount = 0
-		}
-		lastBest = best
-
-		// Fitness is supposed to be minimized and is 0-100. We constrict the
-		// tournament as we get closer to the theoretical best score
-		// However, we will increase our tournament size if we have stalled
-		// Note that we also adaptively increase mutation rate and population
-		// size when we stall
-		if best > 33.0 {
-			tournSize = 4
-		} else if best > 4.0 {
-			tournSize = 3
-		} else {
-			tournSize = 2
-		}
-		if stallCount > 1 {
-			xts := 0
-			if stallCount < 15 {
-				xts = 1
-			} else if stallCount < 30 {
-				xts = 2
-			} else {
-				xts = 3
+	engine, err := evo.NewEngine(target, opts)
+	pcheck(err)
+	if len(*resume) > 0 {
+		log.Printf("Resuming from checkpoint %s\n", *resume)
+		cp, pop, err := loadCheckpoint(*resume, target)
+		pcheck(err)
+		// The checkpoint's parameters win, so say so if they replace a flag
+		flags.Visit(func(f *flag.Flag) {
+			if val, ok := cp.Params[f.Name]; ok && f.Value.String() != fmt.Sprint(val) {
+				log.Printf("Checkpoint %s=%v overrides -%s %s\n", f.Name, val, f.Name, f.Value)
 			}
-			tournSize += xts
-		}
+		})
+		opts.MutationRate = cp.Params["mutationRate"]
+		opts.CrossoverRate = cp.Params["crossoverRate"]
+		opts.PopSize = int(cp.Params["popSize"])
+		opts.StallLimit = int(cp.Params["stallLimit"])
+		pcheck(engine.SetOptions(opts))
+		engine.Resume(pop, cp.Generation, cp.StallCount, cp.BestFitness)
+		logEvent(cp.Generation, fmt.Sprintf("resumed from %s (run %s)", *resume, cp.RunID))
+	} else {
+		log.Printf("Created init pop of %d\n", opts.PopSize)
+	}
+	log.Printf("Working with %d cores\n", engine.Cores())
 
-		adaptMutRate = *mutationRate + (0.0035 * float64(stallCount))
-		if adaptMutRate > maxMutRate {
-			adaptMutRate = maxMutRate
-		}
+	// Everything that happens with a finished generation is an observer
+	engine.AddObserver(genLog)
+	engine.AddObserver(snapshots)
+	if prom != nil {
+		engine.AddObserver(prom)
+	}
+	if dash != nil {
+		engine.AddObserver(dash)
+	}
+	for _, anim := range animators {
+		anim := anim
+		engine.AddObserver(evo.ObserverFunc(func(gen *evo.Generation) error {
+			return anim.AddFrame(gen.Population[0].Image())
+		}))
+	}
 
-		// We add 2x stall count for a larger population. The other 2x are
-		// for the adaptive elitism below
-		adaptPopSize = *popSize + (stallCount * 4)
+	stopReason := ""
+	stopping := false
+	for stopReason == "" {
+		generation := engine.Generation()
+
+		// Run time control: apply any commands (and wait while paused)
+		for {
+			for _, cmd := range ctl.Next() {
+				event := cmd.String()
+				switch cmd.Name {
+				case "checkpoint":
+					cpName := filepath.Join(runDir, "checkpoint.json")
+					cur := engine.Options()
+					bestFitness := 0.0
+					if best := engine.Best(); best != nil {
+						bestFitness = best.Fitness()
+					}
+					pcheck(saveCheckpoint(cpName, *runID, generation, engine.StallCount(), bestFitness, map[string]float64{
+						"mutationRate":  cur.MutationRate,
+						"crossoverRate": cur.CrossoverRate,
+						"popSize":       float64(cur.PopSize),
+						"stallLimit":    float64(cur.StallLimit),
+					}, engine.Population()))
+				case "stop":
+					stopping = true
+				case "inject":
+					event = fmt.Sprintf("inject %d immigrants", engine.Inject())
+				case "set":
+					cur := engine.Options()
+					switch cmd.Param {
+					case "mutationRate":
+						cur.MutationRate = cmd.Value
+					case "crossoverRate":
+						cur.CrossoverRate = cmd.Value
+					case "popSize":
+						cur.PopSize = int(cmd.Value)
+					case "stallLimit":
+						cur.StallLimit = int(cmd.Value)
+					}
+					pcheck(engine.SetOptions(cur))
+				}
+				logEvent(generation, event)
+			}
+			if !ctl.Paused() {
+				break
+			}
+		}
 
-		pcheck(dataLog.Write([]string{
-			fmt.Sprintf("%d", generation),
-			fmt.Sprintf("%.5f", best),
-			fmt.Sprintf("%.5f", worst),
-			fmt.Sprintf("%.5f", avg),
-			time.Now().Format("2006-01-02 15:04:05"),
-		}))
-		dataLog.Flush()
-
-		log.Printf(
-			"Gen:%5d PS:%5d SC:%d,TS:%d,MR:%.5f best %.2f <=> avg %.2f <=> worst %.2f\n",
-			generation, len(population),
-			stallCount, tournSize, adaptMutRate,
-			best, avg, worst,
-		)
-
-		population[0].Save(fmt.Sprintf("output/gen-%010d.jpg", generation))
-		population[0].Save("latest.jpg")
-
-		oldPop := population
-		population = Population(make([]*Individual, 0, adaptPopSize+5+(stallCount/2)))
-
-		// Elitism - we keep best 5 individuals AND a shuffled/mutated copy of the best 5
-		// We also adapt to the current stall count
-		for i := 0; i < (5 + stallCount); i++ {
-			population = append(population, oldPop[i])
-			population = append(population, Mutation(Shuffle(oldPop[i]), adaptMutRate))
+		// Additional stopping conditions
+		if stopping {
+			stopReason = "signal"
+			break
+		}
+		if stopReason = engine.StopReason(); stopReason != "" {
+			fmt.Printf("Stopping at generation %d: %s\n", generation, stopReason)
+			break
 		}
 
-		// Now create rest of population with selection/crossover/mutation
-		for len(population) < adaptPopSize {
-			// Select with tournament selection
-			parent1 := Selection(oldPop, tournSize)
-			parent2 := Selection(oldPop, tournSize)
+		_, err := engine.Step(context.Background())
+		pcheck(err)
+	}
 
-			child1, child2 := Crossover(parent1, parent2, *crossOverRate)
+	logEvent(engine.Generation(), "stop: "+stopReason)
+	pcheck(genLog.Close())
+	pcheck(eventLog.Close())
+	for _, anim := range animators {
+		pcheck(anim.Close())
+	}
 
-			population = append(population, Mutation(child1, adaptMutRate))
-			population = append(population, Mutation(child2, adaptMutRate))
+	generations, lastBest := engine.Generation(), 100.0
+	if bestInd := engine.Best(); bestInd != nil {
+		lastBest = bestInd.Fitness()
+		pcheck(bestInd.Save(filepath.Join(runDir, "final."+*format)))
+		pcheck(writeJSON(filepath.Join(runDir, "final-genome.json"), bestInd.ToJSON(), ""))
+		if *layers {
+			layerDir := filepath.Join(runDir, "layers")
+			b := target.Image().Bounds()
+			infos, err := writeLayers(layerDir, bestInd.ToJSON(), b.Dx(), b.Dy(), 4, true, true)
+			pcheck(err)
+			printLayers(layerDir, infos)
 		}
 	}
 
-	os.Exit(0)
+	manifest.Finish(generations, lastBest, stopReason)
+	pcheck(manifest.Save(manifestFileName))
+
+	fmt.Printf("Run:         %s\n", *runID)
+	fmt.Printf("Directory:   %s\n", runDir)
+	fmt.Printf("Stopped:     %s\n", stopReason)
+	fmt.Printf("Generations: %d\n", generations)
+	fmt.Printf("Evaluations: %d\n", target.Evals())
+	fmt.Printf("Best:        %f\n", lastBest)
+	fmt.Printf("Elapsed:     %v\n", time.Since(startTime).Round(time.Second))
 }
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// version is the binary version: set with -ldflags "-X main.version=..."
+var version = "dev"
+
+// Manifest records everything needed to reproduce (and compare) a run. It is
+// written to the run directory when the run starts and again when it ends.
+type Manifest struct {
+	RunID        string            `json:"runId"`
+	Version      string            `json:"version"`
+	GoVersion    string            `json:"goVersion"`
+	Target       string            `json:"target"`
+	TargetSHA256 string            `json:"targetSha256"`
+	Seed         int64             `json:"seed"`
+	Params       map[string]string `json:"params"`
+	StartTime    time.Time         `json:"startTime"`
+	EndTime      *time.Time        `json:"endTime,omitempty"`
+	Generations  int               `json:"generations"`
+	FinalFitness float64           `json:"finalFitness"`
+	StopReason   string            `json:"stopReason,omitempty"`
+}
+
+// outputFlags are the run flags that only change where and how results are
+// written (or how the run is watched and controlled), not what the run
+// computes. A new flag like that in main must be added here so that compare
+// still groups the runs that use it.
+var outputFlags = []string{
+	"runID", "runDir", "cores", "http", "control", "resume",
+	"snapshot", "snapshotEvery", "snapshotKeep", "format",
+	"animate", "fps", "frameSkip", "layers",
+}
+
+// NewManifest creates a manifest for a run starting now. Every flag (set or
+// default) is recorded in Params.
+func NewManifest(runID string, target string, seed int64, flags *flag.FlagSet) (*Manifest, error) {
+	hash, err := fileSHA256(target)
+	if err != nil {
+		return nil, err
+	}
+
+	params := make(map[string]string)
+	flags.VisitAll(func(f *flag.Flag) {
+		params[f.Name] = f.Value.String()
+	})
+
+	return &Manifest{
+		RunID:        runID,
+		Version:      version,
+		GoVersion:    runtime.Version(),
+		Target:       target,
+		TargetSHA256: hash,
+		Seed:         seed,
+		Params:       params,
+		StartTime:    time.Now(),
+		FinalFitness: -1.0,
+	}, nil
+}
+
+// Finish records the end of the run and why it stopped
+func (m *Manifest) Finish(generations int, finalFitness float64, stopReason string) {
+	now := time.Now()
+	m.EndTime = &now
+	m.Generations = generations
+	m.FinalFitness = finalFitness
+	m.StopReason = stopReason
+}
+
+// Save writes the manifest as JSON
+func (m *Manifest) Save(fileName string) error {
+	return writeJSON(fileName, m, "  ")
+}
+
+// LoadManifest reads a manifest written by Save
+func LoadManifest(fileName string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// fileSHA256 returns the hex encoded SHA-256 of a file's contents
+func fileSHA256(fileName string) (string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// newRunID creates a run ID from the start time, target image, and seed
+func newRunID(start time.Time, target string, seed int64) string {
+	base := filepath.Base(target)
+	base = base[:len(base)-len(filepath.Ext(base))]
+	return start.Format("20060102-150405") + "-" + base + "-" + hex.EncodeToString([]byte{
+		byte(seed >> 16), byte(seed >> 8), byte(seed),
+	})
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/CraigKelly/evoimage/evo"
+)
+
+// evalBuckets are the upper bounds (in seconds) of the evaluation latency histogram
+var evalBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// metrics collects run metrics and serves them in the Prometheus text
+// exposition format. We write the format ourselves rather than pull in a
+// client library.
+type metrics struct {
+	mu     sync.Mutex
+	runID  string
+	target *evo.ImageTarget
+
+	generation  int
+	generations uint64
+	best        float64
+	avg         float64
+	worst       float64
+	stallCount  int
+	popSize     int
+	evalsPerSec float64
+
+	evalCounts []uint64 // Per bucket (not cumulative)
+	evalSum    float64
+	evalCount  uint64
+	lastEvals  uint64
+}
+
+func newMetrics(runID string, target *evo.ImageTarget) *metrics {
+	return &metrics{
+		runID:      runID,
+		target:     target,
+		evalCounts: make([]uint64, len(evalBuckets)+1),
+	}
+}
+
+// Observe records a generation
+func (m *metrics) Observe(gen *evo.Generation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.generation = gen.Gen
+	m.generations++
+	m.best, m.avg, m.worst = gen.Best, gen.Avg, gen.Worst
+	m.stallCount = gen.StallCount
+	m.popSize = gen.PopSize
+
+	// Evaluation latency for the generation
+	secs := gen.EvalTime.Seconds()
+	idx := 0
+	for idx < len(evalBuckets) && secs > evalBuckets[idx] {
+		idx++
+	}
+	m.evalCounts[idx]++
+	m.evalSum += secs
+	m.evalCount++
+
+	if secs > 0.0 {
+		m.evalsPerSec = float64(gen.Evals-m.lastEvals) / secs
+	}
+	m.lastEvals = gen.Evals
+	return nil
+}
+
+// Handle adds the /metrics endpoint to the mux
+func (m *metrics) Handle(mux *http.ServeMux) {
+	mux.HandleFunc("/metrics", m.serveMetrics)
+}
+
+// promValue writes a single metric with its HELP and TYPE lines
+func promValue(w io.Writer, name string, kind string, help string, val float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, val)
+}
+
+// promLabel escapes a label value
+func promLabel(val string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(val)
+}
+
+func (m *metrics) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP evoimage_run_info Run being evolved (always 1)\n# TYPE evoimage_run_info gauge\n")
+	fmt.Fprintf(w, "evoimage_run_info{run_id=\"%s\",target=\"%s\",version=\"%s\"} 1\n",
+		promLabel(m.runID), promLabel(m.target.FileName()), promLabel(version))
+
+	promValue(w, "evoimage_generation", "gauge", "Current generation", float64(m.generation))
+	promValue(w, "evoimage_generations_total", "counter", "Generations completed by this process", float64(m.generations))
+	promValue(w, "evoimage_fitness_best", "gauge", "Best fitness in the current generation (minimized, 0-100)", m.best)
+	promValue(w, "evoimage_fitness_average", "gauge", "Average fitness in the current generation", m.avg)
+	promValue(w, "evoimage_fitness_worst", "gauge", "Worst fitness in the current generation", m.worst)
+	promValue(w, "evoimage_stall_count", "gauge", "Generations since the best fitness improved", float64(m.stallCount))
+	promValue(w, "evoimage_population_size", "gauge", "Size of the current population", float64(m.popSize))
+	promValue(w, "evoimage_evaluations_total", "counter", "Fitness evaluations performed", float64(m.target.Evals()))
+	promValue(w, "evoimage_evaluations_per_second", "gauge", "Fitness evaluations per second in the last generation", m.evalsPerSec)
+
+	name := "evoimage_eval_seconds"
+	fmt.Fprintf(w, "# HELP %s Wall time spent evaluating each generation\n# TYPE %s histogram\n", name, name)
+	cumulative := uint64(0)
+	for idx, le := range evalBuckets {
+		cumulative += m.evalCounts[idx]
+		fmt.Fprintf(w, "%s_bucket{le=\"%v\"} %d\n", name, le, cumulative)
+	}
+	cumulative += m.evalCounts[len(evalBuckets)]
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, cumulative)
+	fmt.Fprintf(w, "%s_sum %v\n%s_count %d\n", name, m.evalSum, name, m.evalCount)
+
+	// Go runtime memory: individuals keep their rendered image, so memory
+	// grows with population size
+	promValue(w, "go_goroutines", "gauge", "Number of goroutines", float64(runtime.NumGoroutine()))
+	promValue(w, "go_memstats_alloc_bytes", "gauge", "Bytes allocated and still in use", float64(mem.Alloc))
+	promValue(w, "go_memstats_alloc_bytes_total", "counter", "Total bytes allocated, even if freed", float64(mem.TotalAlloc))
+	promValue(w, "go_memstats_sys_bytes", "gauge", "Bytes obtained from the system", float64(mem.Sys))
+	promValue(w, "go_memstats_heap_inuse_bytes", "gauge", "Heap bytes in use", float64(mem.HeapInuse))
+	promValue(w, "go_memstats_heap_objects", "gauge", "Number of allocated heap objects", float64(mem.HeapObjects))
+	promValue(w, "go_memstats_mallocs_total", "counter", "Total number of mallocs", float64(mem.Mallocs))
+	promValue(w, "go_gc_cycles_total", "counter", "Completed GC cycles", float64(mem.NumGC))
+	promValue(w, "go_gc_pause_seconds_total", "counter", "Total GC stop-the-world pause time", float64(mem.PauseTotalNs)/1e9)
+}
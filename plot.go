@@ -0,0 +1,584 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang/freetype/truetype"
+	"github.com/llgcode/draw2d"
+	"github.com/llgcode/draw2d/draw2dimg"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// Canvas - the few drawing operations a plot needs, as PNG or SVG
+
+// Text anchors
+const (
+	anchorLeft = iota
+	anchorMiddle
+	anchorRight
+)
+
+// plotCanvas is a drawing surface with y going down
+type plotCanvas interface {
+	Line(pts [][2]float64, c color.RGBA, width float64)
+	Rect(x0, y0, x1, y1 float64, fill color.RGBA)
+	Text(s string, x, y float64, size float64, anchor int, c color.RGBA) // y is the baseline
+	Save(fileName string) error
+}
+
+// newCanvas picks PNG or SVG from the file extension
+func newCanvas(fileName string, width, height int) (plotCanvas, error) {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".png":
+		return newPNGCanvas(width, height), nil
+	case ".svg":
+		return newSVGCanvas(width, height), nil
+	}
+	return nil, errors.New("Unknown plot format (use .png or .svg): " + fileName)
+}
+
+// The Go fonts are vendored, so we don't depend on fonts installed on the box
+var plotFontData = draw2d.FontData{Name: "goregular", Family: draw2d.FontFamilySans}
+var plotFontOnce sync.Once
+
+func registerPlotFont() {
+	plotFontOnce.Do(func() {
+		font, err := truetype.Parse(goregular.TTF)
+		pcheck(err)
+		draw2d.RegisterFont(plotFontData, font)
+	})
+}
+
+// pngCanvas draws with draw2d
+type pngCanvas struct {
+	img *image.RGBA
+	gc  *draw2dimg.GraphicContext
+}
+
+func newPNGCanvas(width, height int) *pngCanvas {
+	registerPlotFont()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.White, image.ZP, draw.Src)
+
+	gc := draw2dimg.NewGraphicContext(img)
+	gc.SetDPI(72) // So font sizes are in pixels, like SVG
+	gc.SetFontData(plotFontData)
+	return &pngCanvas{img: img, gc: gc}
+}
+
+func (pc *pngCanvas) Line(pts [][2]float64, c color.RGBA, width float64) {
+	if len(pts) < 2 {
+		return
+	}
+	pc.gc.SetStrokeColor(c)
+	pc.gc.SetLineWidth(width)
+	pc.gc.BeginPath()
+	pc.gc.MoveTo(pts[0][0], pts[0][1])
+	for _, pt := range pts[1:] {
+		pc.gc.LineTo(pt[0], pt[1])
+	}
+	pc.gc.Stroke()
+}
+
+func (pc *pngCanvas) Rect(x0, y0, x1, y1 float64, fill color.RGBA) {
+	pc.gc.SetFillColor(fill)
+	pc.gc.BeginPath()
+	pc.gc.MoveTo(x0, y0)
+	pc.gc.LineTo(x1, y0)
+	pc.gc.LineTo(x1, y1)
+	pc.gc.LineTo(x0, y1)
+	pc.gc.Close()
+	pc.gc.Fill()
+}
+
+func (pc *pngCanvas) Text(s string, x, y float64, size float64, anchor int, c color.RGBA) {
+	pc.gc.SetFontSize(size)
+	pc.gc.SetFillColor(c)
+	left, _, right, _ := pc.gc.GetStringBounds(s)
+	switch anchor {
+	case anchorMiddle:
+		x -= (left + right) / 2.0
+	case anchorRight:
+		x -= right
+	}
+	pc.gc.FillStringAt(s, x, y)
+}
+
+func (pc *pngCanvas) Save(fileName string) error {
+	return draw2dimg.SaveToPngFile(fileName, pc.img)
+}
+
+// svgCanvas writes SVG elements as they are drawn
+type svgCanvas struct {
+	width, height int
+	buf           bytes.Buffer
+}
+
+func newSVGCanvas(width, height int) *svgCanvas {
+	sc := &svgCanvas{width: width, height: height}
+	sc.Rect(0, 0, float64(width), float64(height), color.RGBA{255, 255, 255, 255})
+	return sc
+}
+
+// svgColor returns a color as #rrggbb
+func svgColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// svgNum keeps coordinates short
+func svgNum(v float64) string {
+	return strconv.FormatFloat(v, 'f', 1, 64)
+}
+
+func (sc *svgCanvas) Line(pts [][2]float64, c color.RGBA, width float64) {
+	if len(pts) < 2 {
+		return
+	}
+	coords := make([]string, len(pts))
+	for i, pt := range pts {
+		coords[i] = svgNum(pt[0]) + "," + svgNum(pt[1])
+	}
+	fmt.Fprintf(&sc.buf, "<polyline fill=\"none\" stroke=\"%s\" stroke-width=\"%s\" points=\"%s\"/>\n",
+		svgColor(c), svgNum(width), strings.Join(coords, " "))
+}
+
+func (sc *svgCanvas) Rect(x0, y0, x1, y1 float64, fill color.RGBA) {
+	fmt.Fprintf(&sc.buf, "<rect x=\"%s\" y=\"%s\" width=\"%s\" height=\"%s\" fill=\"%s\"/>\n",
+		svgNum(x0), svgNum(y0), svgNum(x1-x0), svgNum(y1-y0), svgColor(fill))
+}
+
+func (sc *svgCanvas) Text(s string, x, y float64, size float64, anchor int, c color.RGBA) {
+	anchors := []string{"start", "middle", "end"}
+	fmt.Fprintf(&sc.buf, "<text x=\"%s\" y=\"%s\" font-size=\"%s\" text-anchor=\"%s\" fill=\"%s\">",
+		svgNum(x), svgNum(y), svgNum(size), anchors[anchor], svgColor(c))
+	xml.EscapeText(&sc.buf, []byte(s))
+	sc.buf.WriteString("</text>\n")
+}
+
+func (sc *svgCanvas) Save(fileName string) error {
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\" font-family=\"Go, Helvetica, Arial, sans-serif\">\n",
+		sc.width, sc.height, sc.width, sc.height)
+	out.Write(sc.buf.Bytes())
+	out.WriteString("</svg>\n")
+	return ioutil.WriteFile(fileName, out.Bytes(), 0644)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Axes
+
+// plotAxis maps data values to pixels, linearly or on a log scale
+type plotAxis struct {
+	Min, Max float64
+	Log      bool
+}
+
+// valid is false for values that can't be shown on the axis
+func (ax plotAxis) valid(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0) && (!ax.Log || v > 0.0)
+}
+
+func (ax plotAxis) scale(v float64) float64 {
+	if ax.Log {
+		return math.Log10(v)
+	}
+	return v
+}
+
+// pos returns the pixel position of v, where lo is the pixel for Min and hi
+// is the pixel for Max
+func (ax plotAxis) pos(v float64, lo, hi float64) float64 {
+	mn, mx := ax.scale(ax.Min), ax.scale(ax.Max)
+	return lo + (ax.scale(v)-mn)/(mx-mn)*(hi-lo)
+}
+
+// niceStep returns a step of 1, 2 or 5 times a power of 10 giving about n
+// steps over rng
+func niceStep(rng float64, n int) float64 {
+	raw := rng / float64(n)
+	mag := math.Pow(10, math.Floor(math.Log10(raw)))
+	switch norm := raw / mag; {
+	case norm < 1.5:
+		return mag
+	case norm < 3.5:
+		return 2 * mag
+	case norm < 7.5:
+		return 5 * mag
+	}
+	return 10 * mag
+}
+
+// newPlotAxis covers the given values, rounding out to tick marks
+func newPlotAxis(vals []float64, logScale bool, ticks int) plotAxis {
+	ax := plotAxis{Min: math.Inf(1), Max: math.Inf(-1), Log: logScale}
+	for _, v := range vals {
+		if ax.valid(v) {
+			ax.Min = math.Min(ax.Min, v)
+			ax.Max = math.Max(ax.Max, v)
+		}
+	}
+	if math.IsInf(ax.Min, 0) {
+		ax.Min, ax.Max = 1.0, 10.0 // Nothing to show
+	}
+
+	if ax.Log {
+		ax.Min = math.Pow(10, math.Floor(math.Log10(ax.Min)))
+		ax.Max = math.Pow(10, math.Ceil(math.Log10(ax.Max)))
+		if ax.Max <= ax.Min {
+			ax.Max = ax.Min * 10.0
+		}
+		return ax
+	}
+
+	if ax.Max <= ax.Min {
+		pad := math.Max(math.Abs(ax.Min)*0.1, 1.0)
+		ax.Min, ax.Max = ax.Min-pad, ax.Max+pad
+	}
+	step := niceStep(ax.Max-ax.Min, ticks)
+	ax.Min = math.Floor(ax.Min/step) * step
+	ax.Max = math.Ceil(ax.Max/step) * step
+	return ax
+}
+
+// fmtLogTick writes out log ticks in full unless they are huge or tiny
+func fmtLogTick(v float64) string {
+	if v >= 1e6 || v < 1e-3 {
+		return strconv.FormatFloat(v, 'g', 3, 64)
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// ticks returns the tick values and a label for each
+func (ax plotAxis) ticks(n int) ([]float64, []string) {
+	var vals []float64
+	var labels []string
+
+	if ax.Log {
+		lo, hi := int(math.Round(math.Log10(ax.Min))), int(math.Round(math.Log10(ax.Max)))
+		mults := []float64{1}
+		if hi-lo <= 2 {
+			mults = []float64{1, 2, 5} // Few decades, so fill them in
+		}
+		for e := lo; e <= hi; e++ {
+			for _, m := range mults {
+				v := m * math.Pow(10, float64(e))
+				if v <= ax.Max*1.0001 {
+					vals = append(vals, v)
+					labels = append(labels, fmtLogTick(v))
+				}
+			}
+		}
+		return vals, labels
+	}
+
+	step := niceStep(ax.Max-ax.Min, n)
+	prec := 0
+	if step < 1.0 {
+		prec = int(math.Ceil(-math.Log10(step) - 1e-9))
+	}
+	for v := ax.Min; v <= ax.Max+step*1e-6; v += step {
+		vals = append(vals, v)
+		if math.Abs(v) >= 1e6 {
+			labels = append(labels, strconv.FormatFloat(v, 'g', 3, 64))
+		} else {
+			labels = append(labels, strconv.FormatFloat(v, 'f', prec, 64))
+		}
+	}
+	return vals, labels
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Panels
+
+// plotSeries is one line on a panel
+type plotSeries struct {
+	Color color.RGBA
+	X, Y  []float64
+}
+
+// plotPanel is a set of series sharing a y axis
+type plotPanel struct {
+	Label  string
+	Y      plotAxis
+	Series []plotSeries
+	Weight float64 // Share of the plot height
+}
+
+var (
+	plotBlack = color.RGBA{0, 0, 0, 255}
+	plotGrey  = color.RGBA{110, 110, 110, 255}
+	plotGrid  = color.RGBA{225, 225, 225, 255}
+
+	// The usual tab10 colors
+	plotColors = []color.RGBA{
+		{31, 119, 180, 255}, {255, 127, 14, 255}, {44, 160, 44, 255},
+		{214, 39, 40, 255}, {148, 103, 189, 255}, {140, 86, 75, 255},
+		{227, 119, 194, 255}, {127, 127, 127, 255}, {188, 189, 34, 255},
+		{23, 190, 207, 255},
+	}
+)
+
+// drawPanel draws a panel in the given pixel box, with x tick labels only if
+// asked (panels stack with a shared x axis)
+func drawPanel(c plotCanvas, p plotPanel, xAxis plotAxis, left, top, right, bottom float64, xLabels bool) {
+	// Grid and y ticks
+	yTicks, yLabels := p.Y.ticks(int(math.Max(2, (bottom-top)/40)))
+	for i, v := range yTicks {
+		y := p.Y.pos(v, bottom, top)
+		c.Line([][2]float64{{left, y}, {right, y}}, plotGrid, 1)
+		c.Text(yLabels[i], left-6, y+4, 11, anchorRight, plotGrey)
+	}
+	xTicks, xTickLabels := xAxis.ticks(8)
+	for i, v := range xTicks {
+		x := xAxis.pos(v, left, right)
+		c.Line([][2]float64{{x, top}, {x, bottom}}, plotGrid, 1)
+		if xLabels {
+			c.Text(xTickLabels[i], x, bottom+16, 11, anchorMiddle, plotGrey)
+		}
+	}
+
+	// Data: points off a log axis are dropped
+	for _, s := range p.Series {
+		pts := make([][2]float64, 0, len(s.X))
+		for i := range s.X {
+			if xAxis.valid(s.X[i]) && p.Y.valid(s.Y[i]) {
+				pts = append(pts, [2]float64{xAxis.pos(s.X[i], left, right), p.Y.pos(s.Y[i], bottom, top)})
+			}
+		}
+		c.Line(pts, s.Color, 1.5)
+	}
+
+	c.Line([][2]float64{{left, top}, {right, top}, {right, bottom}, {left, bottom}, {left, top}}, plotGrey, 1)
+	c.Text(p.Label, left+6, top+14, 12, anchorLeft, plotBlack)
+}
+
+// decimate keeps at most about n points of a run, always keeping the last
+func decimate(rows []logRow, n int) []logRow {
+	if len(rows) <= n {
+		return rows
+	}
+	step := (len(rows) + n - 1) / n
+	kept := make([]logRow, 0, n+1)
+	for i := 0; i < len(rows); i += step {
+		kept = append(kept, rows[i])
+	}
+	if last := rows[len(rows)-1]; kept[len(kept)-1].Gen != last.Gen {
+		kept = append(kept, last)
+	}
+	return kept
+}
+
+// plotParams are the adaptive parameters shown under the fitness panel
+var plotParams = []string{"MutRate", "TournSize", "PopSize"}
+
+// plotOptions control the plot command
+type plotOptions struct {
+	Axis         string
+	LogX, LogY   bool
+	Params       bool
+	Width        int
+	Height       int
+	Title        string
+	MaxPoints    int
+	ColorByGroup bool
+}
+
+// plotRuns draws the fitness curves of the runs (and their adaptive
+// parameters) on the canvas
+func plotRuns(c plotCanvas, runs []*runData, opts plotOptions) {
+	// Colors and legend: by group if runs are repeats, otherwise by run
+	var keys []string
+	keyColor := make(map[string]color.RGBA)
+	runColor := make([]color.RGBA, len(runs))
+	for i, run := range runs {
+		key := run.Name
+		if opts.ColorByGroup {
+			key = run.Group
+		}
+		if _, ok := keyColor[key]; !ok {
+			keyColor[key] = plotColors[len(keys)%len(plotColors)]
+			keys = append(keys, key)
+		}
+		runColor[i] = keyColor[key]
+	}
+
+	// Series for the fitness panel and each parameter panel we have data for
+	var allX []float64
+	fitness := plotPanel{Label: "Best fitness", Weight: 3}
+	var allFit []float64
+	params := make([]plotPanel, len(plotParams))
+	paramVals := make([][]float64, len(plotParams))
+	for i, name := range plotParams {
+		params[i] = plotPanel{Label: name, Weight: 1}
+	}
+
+	for i, run := range runs {
+		rows := decimate(run.Rows, opts.MaxPoints)
+		fs := plotSeries{Color: runColor[i]}
+		pss := make([]plotSeries, len(plotParams))
+		for _, row := range rows {
+			x := axisValue(row, opts.Axis)
+			if opts.Axis == "gen" {
+				x++ // Generations run, so a log axis can show the first one
+			}
+			allX = append(allX, x)
+			fs.X = append(fs.X, x)
+			fs.Y = append(fs.Y, row.Best)
+			allFit = append(allFit, row.Best)
+			for p, name := range plotParams {
+				if v, ok := row.Cols[name]; ok {
+					pss[p].X = append(pss[p].X, x)
+					pss[p].Y = append(pss[p].Y, v)
+					paramVals[p] = append(paramVals[p], v)
+				}
+			}
+		}
+		fitness.Series = append(fitness.Series, fs)
+		for p := range plotParams {
+			if len(pss[p].X) > 0 {
+				pss[p].Color = runColor[i]
+				params[p].Series = append(params[p].Series, pss[p])
+			}
+		}
+	}
+
+	fitness.Y = newPlotAxis(allFit, opts.LogY, 6)
+	panels := []plotPanel{fitness}
+	if opts.Params {
+		for p := range params {
+			if len(params[p].Series) > 0 {
+				params[p].Y = newPlotAxis(paramVals[p], false, 2)
+				panels = append(panels, params[p])
+			}
+		}
+	}
+	xAxis := newPlotAxis(allX, opts.LogX, 8)
+
+	// Layout: stacked panels sharing the x axis
+	const gap = 16.0
+	left, right := 64.0, float64(opts.Width)-20.0
+	top, bottom := 36.0, float64(opts.Height)-46.0
+	totalWeight := 0.0
+	for _, p := range panels {
+		totalWeight += p.Weight
+	}
+	avail := bottom - top - gap*float64(len(panels)-1)
+
+	c.Text(opts.Title, float64(opts.Width)/2.0, 22, 15, anchorMiddle, plotBlack)
+	y := top
+	for i, p := range panels {
+		h := avail * p.Weight / totalWeight
+		drawPanel(c, p, xAxis, left, y, right, y+h, i == len(panels)-1)
+		y += h + gap
+	}
+
+	xName := map[string]string{"gen": "Generation", "evals": "Fitness evaluations", "time": "Seconds"}[opts.Axis]
+	if opts.LogX {
+		xName += " (log)"
+	}
+	c.Text(xName, (left+right)/2.0, bottom+34, 12, anchorMiddle, plotBlack)
+
+	// Legend in the top right of the fitness panel, where curves that start
+	// high and fall rarely are
+	if len(keys) > 1 {
+		const maxLegend = 15
+		ly := top + 10.0
+		for i, key := range keys {
+			if i == maxLegend {
+				c.Text(fmt.Sprintf("... and %d more", len(keys)-maxLegend), right-10, ly+4, 11, anchorRight, plotGrey)
+				break
+			}
+			c.Line([][2]float64{{right - 30, ly}, {right - 10, ly}}, keyColor[key], 2.5)
+			c.Text(key, right-36, ly+4, 11, anchorRight, plotBlack)
+			ly += 15
+		}
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Plot command
+
+// plotMain is the entry point for the plot command
+func plotMain(args []string) {
+	flags := flag.NewFlagSet("evoimage plot", flag.ExitOnError)
+	out := flags.String("out", "fitness.png", "Plot file name: .png or .svg")
+	axis := flags.String("by", "gen", "X axis: gen, evals or time (seconds)")
+	logX := flags.Bool("logx", false, "Use a log scale for the x axis")
+	logY := flags.Bool("logy", false, "Use a log scale for fitness")
+	params := flags.Bool("params", true, "Plot the adaptive parameters (MutRate, TournSize, PopSize) under the fitness")
+	width := flags.Int("width", 1000, "Plot width in pixels")
+	height := flags.Int("height", 700, "Plot height in pixels")
+	title := flags.String("title", "", "Plot title (default lists the runs)")
+	maxPoints := flags.Int("maxPoints", 2000, "Maximum points plotted per run")
+	flags.Usage = func() {
+		log.Printf("Usage: evoimage plot [options] run_dir|runs_dir|archive.tar.gz ...\n")
+		flags.PrintDefaults()
+	}
+
+	pcheck(flags.Parse(args))
+	if flags.NArg() < 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if *axis != "gen" && *axis != "evals" && *axis != "time" {
+		pcheck(errors.New("Invalid axis - must be gen, evals or time"))
+	}
+	if *width < 200 || *height < 200 {
+		pcheck(errors.New("Plots must be at least 200x200"))
+	}
+	if *maxPoints < 2 {
+		pcheck(errors.New("Need at least 2 points per run"))
+	}
+
+	runs, err := loadRuns(flags.Args())
+	pcheck(err)
+	if len(runs) < 1 {
+		pcheck(errors.New("No runs to plot"))
+	}
+	assignGroups(runs)
+
+	opts := plotOptions{
+		Axis:      *axis,
+		LogX:      *logX,
+		LogY:      *logY,
+		Params:    *params,
+		Width:     *width,
+		Height:    *height,
+		Title:     *title,
+		MaxPoints: *maxPoints,
+	}
+	names, _ := groupRuns(runs)
+	opts.ColorByGroup = len(names) < len(runs)
+	if len(opts.Title) < 1 {
+		if len(runs) == 1 {
+			opts.Title = runs[0].Name
+		} else {
+			opts.Title = fmt.Sprintf("%d runs", len(runs))
+			if opts.ColorByGroup {
+				opts.Title += fmt.Sprintf(" in %d configurations", len(names))
+			}
+		}
+	}
+
+	c, err := newCanvas(*out, *width, *height)
+	pcheck(err)
+	plotRuns(c, runs, opts)
+	pcheck(c.Save(*out))
+	log.Printf("Plotted %d runs to %s\n", len(runs), *out)
+}
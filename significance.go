@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// pairStats compares the final fitness of two configurations
+type pairStats struct {
+	A, B             string
+	NA, NB           int
+	MedianA, MedianB float64
+	MannWhitney      MannWhitneyResult
+	Paired           int // Seeds run in both configurations
+	Wilcoxon         WilcoxonResult
+	A12              float64
+}
+
+// postHocStats is a pairwise Wilcoxon test on the Friedman blocks
+type postHocStats struct {
+	A, B string
+	P    float64
+	Holm float64
+}
+
+// sigReport holds every significance test between the groups of a compare
+type sigReport struct {
+	Alpha    float64
+	Groups   []string
+	Pairs    []pairStats
+	Friedman *FriedmanResult // Only for more than 2 configurations
+	PostHoc  []postHocStats
+}
+
+// runSeed identifies the seed of a run so that we can pair runs across
+// configurations. Runs without a manifest can't be paired.
+func runSeed(run *runData) (int64, bool) {
+	if run.Manifest == nil {
+		return 0, false
+	}
+	return run.Manifest.Seed, true
+}
+
+// bySeed returns the final fitness of each seed in a group (the first run
+// wins if a seed was repeated)
+func bySeed(grp []*runData) map[int64]float64 {
+	finals := make(map[int64]float64)
+	for _, run := range grp {
+		if seed, ok := runSeed(run); ok {
+			if _, dup := finals[seed]; !dup {
+				finals[seed] = run.finalFitness()
+			}
+		}
+	}
+	return finals
+}
+
+// finals returns the final fitness of every run in a group
+func finals(grp []*runData) []float64 {
+	vals := make([]float64, 0, len(grp))
+	for _, run := range grp {
+		vals = append(vals, run.finalFitness())
+	}
+	return vals
+}
+
+// newSigReport runs the significance tests: every pair of groups gets the
+// Mann-Whitney U test, the Wilcoxon signed-rank test on the runs paired by
+// seed, and the A12 effect size. With more than 2 groups we also run the
+// Friedman test on the seeds every group has, with Holm corrected pairwise
+// Wilcoxon tests as the post-hoc.
+func newSigReport(names []string, groups map[string][]*runData, alpha float64) *sigReport {
+	rep := &sigReport{Alpha: alpha, Groups: names}
+
+	seeds := make(map[string]map[int64]float64)
+	for _, name := range names {
+		seeds[name] = bySeed(groups[name])
+	}
+
+	for i := 0; i < len(names); i++ {
+		for j := i + 1; j < len(names); j++ {
+			a, b := finals(groups[names[i]]), finals(groups[names[j]])
+			ps := pairStats{
+				A: names[i], B: names[j],
+				NA: len(a), NB: len(b),
+				MedianA: median(a), MedianB: median(b),
+				MannWhitney: MannWhitney(a, b),
+				A12:         A12(a, b),
+			}
+
+			var pa, pb []float64
+			for seed, fa := range seeds[names[i]] {
+				if fb, ok := seeds[names[j]][seed]; ok {
+					pa = append(pa, fa)
+					pb = append(pb, fb)
+				}
+			}
+			ps.Paired = len(pa)
+			if ps.Paired > 0 {
+				ps.Wilcoxon = Wilcoxon(pa, pb)
+			}
+			rep.Pairs = append(rep.Pairs, ps)
+		}
+	}
+
+	if len(names) > 2 {
+		rep.friedman(names, seeds)
+	}
+	return rep
+}
+
+// friedman runs the Friedman test and post-hoc tests on the complete blocks:
+// the seeds that were run in every configuration
+func (rep *sigReport) friedman(names []string, seeds map[string]map[int64]float64) {
+	var common []int64
+	for seed := range seeds[names[0]] {
+		inAll := true
+		for _, name := range names[1:] {
+			if _, ok := seeds[name][seed]; !ok {
+				inAll = false
+				break
+			}
+		}
+		if inAll {
+			common = append(common, seed)
+		}
+	}
+	if len(common) < 2 {
+		return // Not enough to say anything
+	}
+	sort.Slice(common, func(i, j int) bool { return common[i] < common[j] })
+
+	blocks := make([][]float64, len(common))
+	for b, seed := range common {
+		blocks[b] = make([]float64, len(names))
+		for t, name := range names {
+			blocks[b][t] = seeds[name][seed]
+		}
+	}
+	res := Friedman(blocks)
+	rep.Friedman = &res
+
+	var ps []float64
+	for i := 0; i < len(names); i++ {
+		for j := i + 1; j < len(names); j++ {
+			a := make([]float64, len(blocks))
+			b := make([]float64, len(blocks))
+			for k, block := range blocks {
+				a[k], b[k] = block[i], block[j]
+			}
+			p := Wilcoxon(a, b).P
+			rep.PostHoc = append(rep.PostHoc, postHocStats{A: names[i], B: names[j], P: p})
+			ps = append(ps, p)
+		}
+	}
+	for i, adj := range Holm(ps) {
+		rep.PostHoc[i].Holm = adj
+	}
+}
+
+// sigMark flags a significant p-value
+func (rep *sigReport) sigMark(p float64) string {
+	if p < rep.Alpha {
+		return " *"
+	}
+	return ""
+}
+
+// fmtP formats a p-value for a report
+func fmtP(p float64) string {
+	if p < 0.0001 {
+		return "<0.0001"
+	}
+	return fmtFloat(p, true, 4)
+}
+
+// WriteMarkdown writes the report as Markdown tables
+func (rep *sigReport) WriteMarkdown(w io.Writer) {
+	fmt.Fprintf(w, "### Pairwise tests on final fitness\n\n")
+	fmt.Fprintf(w, "A12 is the probability that a run of A ends with a lower (better) fitness than a run of B. ")
+	fmt.Fprintf(w, "Wilcoxon pairs runs by seed. * marks p < %v.\n\n", rep.Alpha)
+	fmt.Fprintf(w, "| A | B | nA | nB | Median A | Median B | U | p (U) | Pairs | W | p (W) | A12 | Effect |\n")
+	fmt.Fprintf(w, "|---|---|---:|---:|---:|---:|---:|---:|---:|---:|---:|---:|---|\n")
+	for _, ps := range rep.Pairs {
+		wCol, wpCol := "-", "-"
+		if ps.Paired > 0 {
+			wCol = fmtFloat(ps.Wilcoxon.W, true, 1)
+			wpCol = fmtP(ps.Wilcoxon.P) + rep.sigMark(ps.Wilcoxon.P)
+		}
+		fmt.Fprintf(w, "| %s | %s | %d | %d | %s | %s | %s | %s | %d | %s | %s | %s | %s |\n",
+			ps.A, ps.B, ps.NA, ps.NB,
+			fmtFloat(ps.MedianA, true, 4), fmtFloat(ps.MedianB, true, 4),
+			fmtFloat(ps.MannWhitney.U, true, 1), fmtP(ps.MannWhitney.P)+rep.sigMark(ps.MannWhitney.P),
+			ps.Paired, wCol, wpCol,
+			fmtFloat(ps.A12, true, 3), A12Magnitude(ps.A12),
+		)
+	}
+
+	if len(rep.Groups) < 3 {
+		return
+	}
+	fmt.Fprintf(w, "\n### Friedman test\n\n")
+	if rep.Friedman == nil {
+		fmt.Fprintf(w, "Skipped: fewer than 2 seeds were run in every configuration.\n")
+		return
+	}
+	f := rep.Friedman
+	fmt.Fprintf(w, "%d configurations over %d seeds: chi-square = %s, df = %d, p = %s%s\n\n",
+		f.K, f.N, fmtFloat(f.ChiSq, true, 3), f.K-1, fmtP(f.P), rep.sigMark(f.P))
+	fmt.Fprintf(w, "| Configuration | Mean rank |\n|---|---:|\n")
+	for i, name := range rep.Groups {
+		fmt.Fprintf(w, "| %s | %s |\n", name, fmtFloat(f.MeanRanks[i], true, 2))
+	}
+
+	fmt.Fprintf(w, "\n### Post-hoc: pairwise Wilcoxon (Holm corrected)\n\n")
+	fmt.Fprintf(w, "| A | B | p | p (Holm) |\n|---|---|---:|---:|\n")
+	for _, ph := range rep.PostHoc {
+		fmt.Fprintf(w, "| %s | %s | %s | %s%s |\n", ph.A, ph.B, fmtP(ph.P), fmtP(ph.Holm), rep.sigMark(ph.Holm))
+	}
+}
+
+// WriteCSV writes the report as a single CSV table with one row per test
+func (rep *sigReport) WriteCSV(fileName string) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+
+	num := func(v float64) string { return strconv.FormatFloat(v, 'g', 6, 64) }
+	w := csv.NewWriter(f)
+	w.Write([]string{"Test", "A", "B", "N", "Statistic", "P", "PHolm", "Effect"})
+	for _, ps := range rep.Pairs {
+		n := fmt.Sprintf("%d/%d", ps.NA, ps.NB)
+		w.Write([]string{"mann-whitney", ps.A, ps.B, n, num(ps.MannWhitney.U), num(ps.MannWhitney.P), "", ""})
+		if ps.Paired > 0 {
+			w.Write([]string{"wilcoxon", ps.A, ps.B, fmt.Sprintf("%d", ps.Paired), num(ps.Wilcoxon.W), num(ps.Wilcoxon.P), "", ""})
+		}
+		w.Write([]string{"a12", ps.A, ps.B, n, num(ps.A12), "", "", A12Magnitude(ps.A12)})
+	}
+	if fr := rep.Friedman; fr != nil {
+		w.Write([]string{"friedman", strings.Join(rep.Groups, ";"), "", fmt.Sprintf("%d", fr.N), num(fr.ChiSq), num(fr.P), "", ""})
+		for i, name := range rep.Groups {
+			w.Write([]string{"mean-rank", name, "", fmt.Sprintf("%d", fr.N), num(fr.MeanRanks[i]), "", "", ""})
+		}
+		for _, ph := range rep.PostHoc {
+			w.Write([]string{"post-hoc-wilcoxon", ph.A, ph.B, fmt.Sprintf("%d", fr.N), "", num(ph.P), num(ph.Holm), ""})
+		}
+	}
+	w.Flush()
+
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/CraigKelly/evoimage/evo"
+)
+
+// snapshotWriter saves the best individual of a generation according to a
+// retention policy:
+//
+//	all     - every generation (the old behavior)
+//	improve - only when the best fitness improved
+//	every   - every N generations
+//	log     - log-spaced generations: 0-9, 10, 20, ..., 90, 100, 200, ...
+//
+// If keep is greater than 0, only the last keep snapshots are kept on disk.
+// The current best is always available as latest.<format>.
+type snapshotWriter struct {
+	dir      string
+	policy   string
+	format   string
+	every    int
+	keep     int
+	lastBest float64
+	saved    []string
+}
+
+// newSnapshotWriter validates the policy and format (jpg or png)
+func newSnapshotWriter(dir string, policy string, format string, every int, keep int) (*snapshotWriter, error) {
+	switch policy {
+	case "all", "improve", "every", "log":
+	default:
+		return nil, errors.New("Invalid snapshot policy - must be all, improve, every or log")
+	}
+	if format != "jpg" && format != "png" {
+		return nil, errors.New("Invalid format - must be jpg or png")
+	}
+	if every < 1 {
+		return nil, errors.New("Snapshot interval must be >= 1")
+	}
+	if keep < 0 {
+		return nil, errors.New("Snapshot keep count must be >= 0")
+	}
+
+	return &snapshotWriter{
+		dir:      dir,
+		policy:   policy,
+		format:   format,
+		every:    every,
+		keep:     keep,
+		lastBest: -1.0,
+	}, nil
+}
+
+// logSpaced is true for generations 0-9, then 10, 20, ..., 90, then 100,
+// 200, ..., 900, etc
+func logSpaced(generation int) bool {
+	step := 1
+	for generation >= step*10 {
+		step *= 10
+	}
+	return generation%step == 0
+}
+
+// wanted returns true if the policy says we should save this generation
+func (sw *snapshotWriter) wanted(generation int, improved bool) bool {
+	switch sw.policy {
+	case "improve":
+		return improved
+	case "every":
+		return generation%sw.every == 0
+	case "log":
+		return logSpaced(generation)
+	}
+	return true
+}
+
+// Save handles the best individual of a generation. Note that the individual
+// must already be evaluated.
+func (sw *snapshotWriter) Save(generation int, best *evo.Individual) error {
+	fitness := best.Fitness()
+	improved := sw.lastBest < 0.0 || fitness < sw.lastBest
+	if improved {
+		sw.lastBest = fitness
+		if err := best.Save(filepath.Join(sw.dir, "latest."+sw.format)); err != nil {
+			return err
+		}
+	}
+
+	if !sw.wanted(generation, improved) {
+		return nil
+	}
+
+	fileName := filepath.Join(sw.dir, fmt.Sprintf("gen-%010d.%s", generation, sw.format))
+	if err := best.Save(fileName); err != nil {
+		return err
+	}
+
+	if sw.keep > 0 {
+		sw.saved = append(sw.saved, fileName)
+		for len(sw.saved) > sw.keep {
+			if err := os.Remove(sw.saved[0]); err != nil {
+				return err
+			}
+			sw.saved = sw.saved[1:]
+		}
+	}
+
+	return nil
+}
+
+// Observe saves the best individual of each generation
+func (sw *snapshotWriter) Observe(gen *evo.Generation) error {
+	return sw.Save(gen.Gen, gen.Population[0])
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// Descriptive statistics
+
+// meanStdDev returns the mean and sample standard deviation
+func meanStdDev(vals []float64) (float64, float64) {
+	if len(vals) < 1 {
+		return math.NaN(), math.NaN()
+	}
+	mean := 0.0
+	for _, v := range vals {
+		mean += v
+	}
+	mean /= float64(len(vals))
+	if len(vals) < 2 {
+		return mean, 0.0
+	}
+
+	ss := 0.0
+	for _, v := range vals {
+		ss += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(ss / float64(len(vals)-1))
+}
+
+// median returns the median without changing vals
+func median(vals []float64) float64 {
+	if len(vals) < 1 {
+		return math.NaN()
+	}
+	sorted := append([]float64(nil), vals...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		return sorted[mid]
+	}
+	return (sorted[mid-1] + sorted[mid]) / 2.0
+}
+
+// tTable is the 97.5% quantile of Student's t distribution for 1-30 degrees
+// of freedom
+var tTable = []float64{
+	12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+// tQuantile975 is used for 95% confidence intervals of a mean
+func tQuantile975(df int) float64 {
+	if df < 1 {
+		return 0.0 // A single run has no spread
+	}
+	if df <= len(tTable) {
+		return tTable[df-1]
+	}
+	return 1.96
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Distributions
+
+// normalCDF is the standard normal cumulative distribution function
+func normalCDF(z float64) float64 {
+	return 0.5 * math.Erfc(-z/math.Sqrt2)
+}
+
+// twoSidedP returns the two-sided p-value for a standard normal z score
+func twoSidedP(z float64) float64 {
+	return math.Min(1.0, 2.0*normalCDF(-math.Abs(z)))
+}
+
+// gammaQ is the regularized upper incomplete gamma function Q(a, x), using
+// the series expansion for small x and a continued fraction otherwise
+func gammaQ(a float64, x float64) float64 {
+	if x <= 0.0 {
+		return 1.0
+	}
+	lg, _ := math.Lgamma(a)
+
+	if x < a+1.0 {
+		sum, term := 1.0/a, 1.0/a
+		for n := 1; n < 1000; n++ {
+			term *= x / (a + float64(n))
+			sum += term
+			if math.Abs(term) < math.Abs(sum)*1e-15 {
+				break
+			}
+		}
+		return 1.0 - sum*math.Exp(-x+a*math.Log(x)-lg)
+	}
+
+	// Lentz's method
+	const tiny = 1e-300
+	b := x + 1.0 - a
+	c := 1.0 / tiny
+	d := 1.0 / b
+	h := d
+	for i := 1; i < 1000; i++ {
+		an := -float64(i) * (float64(i) - a)
+		b += 2.0
+		d = an*d + b
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = b + an/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1.0 / d
+		del := d * c
+		h *= del
+		if math.Abs(del-1.0) < 1e-15 {
+			break
+		}
+	}
+	return math.Exp(-x+a*math.Log(x)-lg) * h
+}
+
+// chiSquareP is the upper tail probability of the chi-square distribution
+func chiSquareP(x float64, df int) float64 {
+	return gammaQ(float64(df)/2.0, x/2.0)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Ranks
+
+// rankTies returns the (1 based) ranks of vals, with tied values getting
+// their average rank. It also returns the sum of t^3 - t over every group of
+// t tied values, which the tests need for their tie corrections.
+func rankTies(vals []float64) ([]float64, float64) {
+	order := make([]int, len(vals))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return vals[order[i]] < vals[order[j]] })
+
+	ranks := make([]float64, len(vals))
+	ties := 0.0
+	for i := 0; i < len(order); {
+		j := i + 1
+		for j < len(order) && vals[order[j]] == vals[order[i]] {
+			j++
+		}
+		avg := float64(i+j+1) / 2.0 // ranks i+1 .. j
+		for k := i; k < j; k++ {
+			ranks[order[k]] = avg
+		}
+		t := float64(j - i)
+		ties += t*t*t - t
+		i = j
+	}
+	return ranks, ties
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Mann-Whitney U
+
+// MannWhitneyResult is the result of a Mann-Whitney U test
+type MannWhitneyResult struct {
+	U     float64 // U statistic for the first sample
+	P     float64 // Two-sided p-value
+	Exact bool    // True if P is exact rather than the normal approximation
+}
+
+// MannWhitney tests whether two independent samples come from the same
+// distribution. Small samples without ties get an exact p-value, otherwise we
+// use the normal approximation with tie and continuity corrections.
+func MannWhitney(a []float64, b []float64) MannWhitneyResult {
+	n1, n2 := len(a), len(b)
+	if n1 < 1 || n2 < 1 {
+		return MannWhitneyResult{P: math.NaN()}
+	}
+
+	ranks, ties := rankTies(append(append([]float64(nil), a...), b...))
+	r1 := 0.0
+	for i := 0; i < n1; i++ {
+		r1 += ranks[i]
+	}
+	u := r1 - float64(n1*(n1+1))/2.0
+	res := MannWhitneyResult{U: u}
+
+	if ties == 0.0 && n1*n2 <= 400 {
+		res.P = exactMannWhitneyP(n1, n2, u)
+		res.Exact = true
+		return res
+	}
+
+	n := float64(n1 + n2)
+	mu := float64(n1*n2) / 2.0
+	sigma := math.Sqrt(float64(n1*n2) / 12.0 * ((n + 1.0) - ties/(n*(n-1.0))))
+	if sigma == 0.0 {
+		res.P = 1.0
+		return res
+	}
+	diff := math.Abs(u-mu) - 0.5
+	if diff < 0.0 {
+		diff = 0.0
+	}
+	res.P = twoSidedP(diff / sigma)
+	return res
+}
+
+// exactMannWhitneyP returns the exact two-sided p-value of U by counting the
+// arrangements of n1 and n2 values with each U
+func exactMannWhitneyP(n1 int, n2 int, u float64) float64 {
+	maxU := n1 * n2
+
+	// counts[i][j][k] = arrangements of i and j values with U = k, built up
+	// one value at a time (we only need the previous i)
+	prev := make([][]float64, n2+1)
+	for j := range prev {
+		prev[j] = make([]float64, maxU+1)
+		prev[j][0] = 1.0
+	}
+	for i := 1; i <= n1; i++ {
+		curr := make([][]float64, n2+1)
+		curr[0] = make([]float64, maxU+1)
+		curr[0][0] = 1.0
+		for j := 1; j <= n2; j++ {
+			curr[j] = make([]float64, maxU+1)
+			for k := 0; k <= i*j; k++ {
+				// The largest value is either from the first sample (and beats
+				// all j of the second) or from the second sample
+				if k >= j {
+					curr[j][k] += prev[j][k-j]
+				}
+				curr[j][k] += curr[j-1][k]
+			}
+		}
+		prev = curr
+	}
+
+	dist := prev[n2]
+	total := 0.0
+	for _, c := range dist {
+		total += c
+	}
+
+	// Two-sided: probability of a U at least as far from the center
+	center := float64(maxU) / 2.0
+	dev := math.Abs(u - center)
+	tail := 0.0
+	for k, c := range dist {
+		if math.Abs(float64(k)-center) >= dev-1e-9 {
+			tail += c
+		}
+	}
+	return math.Min(1.0, tail/total)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Wilcoxon signed-rank
+
+// WilcoxonResult is the result of a Wilcoxon signed-rank test
+type WilcoxonResult struct {
+	N     int     // Pairs with a non-zero difference
+	W     float64 // Sum of the ranks of the positive differences
+	P     float64 // Two-sided p-value
+	Exact bool
+}
+
+// Wilcoxon tests whether paired samples (a[i] and b[i] from the same seed)
+// differ. Zero differences are dropped. Small samples without ties get an
+// exact p-value, otherwise we use the normal approximation.
+func Wilcoxon(a []float64, b []float64) WilcoxonResult {
+	var diffs []float64
+	for i := range a {
+		if d := a[i] - b[i]; d != 0.0 {
+			diffs = append(diffs, d)
+		}
+	}
+	n := len(diffs)
+	if n < 1 {
+		return WilcoxonResult{P: 1.0}
+	}
+
+	abs := make([]float64, n)
+	for i, d := range diffs {
+		abs[i] = math.Abs(d)
+	}
+	ranks, ties := rankTies(abs)
+
+	w := 0.0
+	for i, d := range diffs {
+		if d > 0.0 {
+			w += ranks[i]
+		}
+	}
+	res := WilcoxonResult{N: n, W: w}
+
+	if ties == 0.0 && n <= 30 {
+		res.P = exactWilcoxonP(n, w)
+		res.Exact = true
+		return res
+	}
+
+	fn := float64(n)
+	mu := fn * (fn + 1.0) / 4.0
+	sigma := math.Sqrt(fn*(fn+1.0)*(2.0*fn+1.0)/24.0 - ties/48.0)
+	if sigma == 0.0 {
+		res.P = 1.0
+		return res
+	}
+	diff := math.Abs(w-mu) - 0.5
+	if diff < 0.0 {
+		diff = 0.0
+	}
+	res.P = twoSidedP(diff / sigma)
+	return res
+}
+
+// exactWilcoxonP returns the exact two-sided p-value of W by counting the
+// subsets of ranks 1..n with each sum
+func exactWilcoxonP(n int, w float64) float64 {
+	maxW := n * (n + 1) / 2
+	counts := make([]float64, maxW+1)
+	counts[0] = 1.0
+	for r := 1; r <= n; r++ {
+		for s := maxW; s >= r; s-- {
+			counts[s] += counts[s-r]
+		}
+	}
+
+	total := math.Pow(2.0, float64(n))
+	center := float64(maxW) / 2.0
+	dev := math.Abs(w - center)
+	tail := 0.0
+	for s, c := range counts {
+		if math.Abs(float64(s)-center) >= dev-1e-9 {
+			tail += c
+		}
+	}
+	return math.Min(1.0, tail/total)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Vargha-Delaney A12
+
+// A12 is the Vargha-Delaney effect size: the probability that a run from a
+// has a *lower* (better) fitness than a run from b, counting ties as half.
+// 0.5 means no difference.
+func A12(a []float64, b []float64) float64 {
+	if len(a) < 1 || len(b) < 1 {
+		return math.NaN()
+	}
+	wins := 0.0
+	for _, x := range a {
+		for _, y := range b {
+			if x < y {
+				wins += 1.0
+			} else if x == y {
+				wins += 0.5
+			}
+		}
+	}
+	return wins / float64(len(a)*len(b))
+}
+
+// A12Magnitude uses the usual Vargha-Delaney thresholds to describe an effect
+func A12Magnitude(a12 float64) string {
+	d := math.Abs(a12 - 0.5)
+	switch {
+	case math.IsNaN(d):
+		return "-"
+	case d < 0.06:
+		return "negligible"
+	case d < 0.14:
+		return "small"
+	case d < 0.21:
+		return "medium"
+	}
+	return "large"
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Friedman
+
+// FriedmanResult is the result of a Friedman test
+type FriedmanResult struct {
+	K         int       // Number of treatments (configurations)
+	N         int       // Number of blocks (seeds)
+	ChiSq     float64   // Test statistic (tie corrected)
+	P         float64   // Chi-square approximation with K-1 degrees of freedom
+	MeanRanks []float64 // Mean rank of each treatment (1 is best)
+}
+
+// Friedman tests whether k treatments differ, where data[block][treatment]
+// holds the result of every treatment on every block
+func Friedman(data [][]float64) FriedmanResult {
+	n := len(data)
+	if n < 1 || len(data[0]) < 2 {
+		return FriedmanResult{P: math.NaN()}
+	}
+	k := len(data[0])
+
+	rankSums := make([]float64, k)
+	tieSum := 0.0
+	for _, block := range data {
+		ranks, ties := rankTies(block)
+		for j, r := range ranks {
+			rankSums[j] += r
+		}
+		tieSum += ties
+	}
+
+	fn, fk := float64(n), float64(k)
+	ss := 0.0
+	for _, r := range rankSums {
+		ss += r * r
+	}
+	chi := (12.0/(fn*fk*(fk+1.0)))*ss - 3.0*fn*(fk+1.0)
+	denom := 1.0 - tieSum/(fn*fk*(fk*fk-1.0))
+	if denom > 0.0 {
+		chi /= denom
+	}
+
+	res := FriedmanResult{K: k, N: n, ChiSq: chi, P: chiSquareP(chi, k-1)}
+	res.MeanRanks = make([]float64, k)
+	for j, r := range rankSums {
+		res.MeanRanks[j] = r / fn
+	}
+	return res
+}
+
+// Holm adjusts p-values for multiple comparisons (Holm-Bonferroni)
+func Holm(ps []float64) []float64 {
+	order := make([]int, len(ps))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return ps[order[i]] < ps[order[j]] })
+
+	adj := make([]float64, len(ps))
+	running := 0.0
+	for rank, idx := range order {
+		p := math.Min(1.0, ps[idx]*float64(len(ps)-rank))
+		running = math.Max(running, p) // adjusted p-values never decrease
+		adj[idx] = running
+	}
+	return adj
+}
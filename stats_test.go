@@ -0,0 +1,158 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// near checks a value against a reference to within tol
+func near(t *testing.T, name string, got, exp, tol float64) {
+	t.Helper()
+	if math.IsNaN(got) || math.Abs(got-exp) > tol {
+		t.Errorf("%s is %f, expected %f", name, got, exp)
+	}
+}
+
+func TestGammaQ(t *testing.T) {
+	// Q(1, x) = exp(-x) and Q(1/2, x) = erfc(sqrt(x)), covering both the
+	// series (x < a+1) and the continued fraction
+	for _, x := range []float64{0.1, 0.5, 1.5, 3.0, 10.0} {
+		near(t, "gammaQ(1, x)", gammaQ(1.0, x), math.Exp(-x), 1e-12)
+		near(t, "gammaQ(0.5, x)", gammaQ(0.5, x), math.Erfc(math.Sqrt(x)), 1e-12)
+	}
+	near(t, "gammaQ(2, 0)", gammaQ(2.0, 0.0), 1.0, 0.0)
+}
+
+func TestChiSquareP(t *testing.T) {
+	near(t, "chiSquareP(3.84, 1)", chiSquareP(3.84, 1), 0.05, 1e-3)
+	near(t, "chiSquareP(5.991, 2)", chiSquareP(5.991, 2), 0.05, 1e-3)
+	near(t, "chiSquareP(11.07, 5)", chiSquareP(11.07, 5), 0.05, 1e-3)
+	near(t, "chiSquareP(8, 2)", chiSquareP(8.0, 2), math.Exp(-4.0), 1e-12)
+}
+
+func TestRankTies(t *testing.T) {
+	ranks, ties := rankTies([]float64{3, 1, 2, 2, 3, 2, 4, 5})
+	exp := []float64{5.5, 1, 3, 3, 5.5, 3, 7, 8}
+	for i := range exp {
+		near(t, "rank", ranks[i], exp[i], 0.0)
+	}
+	near(t, "ties", ties, 24+6, 0.0) // A group of 3 and a group of 2
+}
+
+func TestMannWhitney(t *testing.T) {
+	// Complete separation: U = 0 and 2 of the 20 arrangements are as extreme
+	res := MannWhitney([]float64{1, 2, 3}, []float64{4, 5, 6})
+	if !res.Exact {
+		t.Error("Small samples without ties should be exact")
+	}
+	near(t, "U", res.U, 0.0, 0.0)
+	near(t, "P", res.P, 0.1, 1e-12)
+
+	// The test is symmetric
+	res = MannWhitney([]float64{4, 5, 6}, []float64{1, 2, 3})
+	near(t, "U", res.U, 9.0, 0.0)
+	near(t, "P", res.P, 0.1, 1e-12)
+
+	// Identical samples can't be told apart
+	res = MannWhitney([]float64{1, 2, 3, 4}, []float64{4, 3, 2, 1})
+	near(t, "P", res.P, 1.0, 0.0)
+
+	// Ties: the normal approximation with tie and continuity corrections
+	// (R: wilcox.test(c(1,2,2,3), c(2,3,4,5)) gives W = 2.5, p = 0.1367)
+	res = MannWhitney([]float64{1, 2, 2, 3}, []float64{2, 3, 4, 5})
+	if res.Exact {
+		t.Error("Ties should use the normal approximation")
+	}
+	near(t, "U", res.U, 2.5, 0.0)
+	near(t, "P", res.P, 0.136658, 1e-6)
+}
+
+func TestWilcoxon(t *testing.T) {
+	// All 5 differences positive: only 2 of the 32 sign patterns are as
+	// extreme
+	res := Wilcoxon([]float64{2, 4, 6, 8, 10}, []float64{1, 2, 3, 4, 5})
+	if !res.Exact || res.N != 5 {
+		t.Errorf("Expected an exact test of 5 pairs, got %+v", res)
+	}
+	near(t, "W", res.W, 15.0, 0.0)
+	near(t, "P", res.P, 0.0625, 1e-12)
+
+	// Zero differences are dropped
+	res = Wilcoxon([]float64{1, 2, 3}, []float64{1, 2, 3})
+	if res.N != 0 || res.P != 1.0 {
+		t.Errorf("Identical samples gave %+v", res)
+	}
+
+	// Tied differences 1, 1, 2, -3, 4, 5: the normal approximation with tie
+	// and continuity corrections
+	res = Wilcoxon([]float64{1, 1, 2, 0, 4, 5}, []float64{0, 0, 0, 3, 0, 0})
+	if res.Exact {
+		t.Error("Ties should use the normal approximation")
+	}
+	near(t, "W", res.W, 17.0, 0.0)
+	near(t, "P", res.P, 0.207160, 1e-6)
+}
+
+func TestA12(t *testing.T) {
+	near(t, "A12", A12([]float64{1, 2}, []float64{2, 3}), 0.875, 0.0)
+	near(t, "A12", A12([]float64{2, 3}, []float64{1, 2}), 0.125, 0.0)
+	near(t, "A12", A12([]float64{1, 2}, []float64{1, 2}), 0.5, 0.0)
+
+	for a12, exp := range map[float64]string{
+		0.5: "negligible", 0.6: "small", 0.32: "medium", 0.875: "large",
+	} {
+		if got := A12Magnitude(a12); got != exp {
+			t.Errorf("A12Magnitude(%f) is %s, expected %s", a12, got, exp)
+		}
+	}
+	if got := A12Magnitude(A12(nil, []float64{1})); got != "-" {
+		t.Errorf("A12Magnitude of no runs is %s", got)
+	}
+}
+
+func TestFriedman(t *testing.T) {
+	// Every block ranks the treatments the same way: rank sums of 4, 8 and
+	// 12 give a statistic of 8, and with 2 degrees of freedom P = exp(-4)
+	data := [][]float64{{1, 2, 3}, {10, 20, 30}, {0.1, 0.2, 0.3}, {5, 6, 7}}
+	res := Friedman(data)
+	if res.K != 3 || res.N != 4 {
+		t.Errorf("Expected 3 treatments and 4 blocks, got %+v", res)
+	}
+	near(t, "ChiSq", res.ChiSq, 8.0, 1e-12)
+	near(t, "P", res.P, math.Exp(-4.0), 1e-12)
+	for j, exp := range []float64{1, 2, 3} {
+		near(t, "MeanRank", res.MeanRanks[j], exp, 0.0)
+	}
+
+	// Ties within a block are corrected for: with blocks {1,1,2} and {1,2,3}
+	// the rank sums are 2.5, 3.5 and 6, the raw statistic is 3.25 and the
+	// correction divides by 1 - 6/(2*3*8)
+	res = Friedman([][]float64{{1, 1, 2}, {1, 2, 3}})
+	near(t, "ChiSq", res.ChiSq, 3.25/(1.0-6.0/48.0), 1e-12)
+}
+
+func TestHolm(t *testing.T) {
+	ps := []float64{0.01, 0.04, 0.03, 0.005}
+	adj := Holm(ps)
+
+	// Sorted: 0.005*4, 0.01*3, 0.03*2, and 0.04*1 is raised to 0.06
+	exp := []float64{0.03, 0.06, 0.06, 0.02}
+	for i := range exp {
+		near(t, "Holm", adj[i], exp[i], 1e-12)
+	}
+
+	// The adjusted values keep the order of the raw p-values, never go below
+	// them and are capped at 1
+	ps = []float64{0.5, 0.001, 0.2, 0.04, 0.9, 0.01}
+	adj = Holm(ps)
+	for i := range ps {
+		if adj[i] < ps[i] || adj[i] > 1.0 {
+			t.Errorf("Adjusted p %f for %f", adj[i], ps[i])
+		}
+		for j := range ps {
+			if ps[i] < ps[j] && adj[i] > adj[j] {
+				t.Errorf("Adjusted %f > %f but raw %f < %f", adj[i], adj[j], ps[i], ps[j])
+			}
+		}
+	}
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// SweepConfig is a grid of run parameters. Every combination of target,
+// gene count, rates, population size and seed is a job, and every job is
+// repeated Repetitions times (repetition r uses seed + r*1000000).
+type SweepConfig struct {
+	Targets        []string  `json:"targets"`
+	GeneCounts     []int     `json:"geneCounts"`
+	MutationRates  []float64 `json:"mutationRates"`
+	CrossoverRates []float64 `json:"crossoverRates"`
+	PopSizes       []int     `json:"popSizes"`
+	Seeds          []int64   `json:"seeds"`
+	Repetitions    int       `json:"repetitions"`
+	Args           []string  `json:"args"` // Extra command line args for every job
+}
+
+// sweepJob is a single run in a sweep
+type sweepJob struct {
+	ID            string
+	Target        string
+	GeneCount     int
+	MutationRate  float64
+	CrossoverRate float64
+	PopSize       int
+	Seed          int64
+
+	Status       string // pending, done, failed, interrupted or skipped
+	Generations  int
+	FinalFitness float64
+	StopReason   string
+	Elapsed      time.Duration
+}
+
+// loadSweepConfig reads a sweep config, filling in defaults for any
+// dimension that wasn't specified
+func loadSweepConfig(fileName string) (*SweepConfig, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &SweepConfig{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+
+	if len(cfg.Targets) < 1 {
+		return nil, errors.New("Sweep config needs at least one target")
+	}
+	if len(cfg.GeneCounts) < 1 {
+		cfg.GeneCounts = []int{100}
+	}
+	if len(cfg.MutationRates) < 1 {
+		cfg.MutationRates = []float64{0.11}
+	}
+	if len(cfg.CrossoverRates) < 1 {
+		cfg.CrossoverRates = []float64{0.60}
+	}
+	if len(cfg.PopSizes) < 1 {
+		cfg.PopSizes = []int{300}
+	}
+	if len(cfg.Seeds) < 1 {
+		cfg.Seeds = []int64{1}
+	}
+	if cfg.Repetitions < 1 {
+		cfg.Repetitions = 1
+	}
+
+	return cfg, nil
+}
+
+// Jobs expands the grid into the list of jobs
+func (cfg *SweepConfig) Jobs() []*sweepJob {
+	var jobs []*sweepJob
+	for _, target := range cfg.Targets {
+		base := filepath.Base(target)
+		base = base[:len(base)-len(filepath.Ext(base))]
+		for _, genes := range cfg.GeneCounts {
+			for _, mut := range cfg.MutationRates {
+				for _, cross := range cfg.CrossoverRates {
+					for _, pop := range cfg.PopSizes {
+						for _, seed := range cfg.Seeds {
+							for rep := 0; rep < cfg.Repetitions; rep++ {
+								jobSeed := seed + int64(rep)*1000000
+								jobs = append(jobs, &sweepJob{
+									ID: fmt.Sprintf("%s-g%d-m%v-x%v-p%d-s%d",
+										base, genes, mut, cross, pop, jobSeed),
+									Target:        target,
+									GeneCount:     genes,
+									MutationRate:  mut,
+									CrossoverRate: cross,
+									PopSize:       pop,
+									Seed:          jobSeed,
+									Status:        "pending",
+								})
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return jobs
+}
+
+// args returns the command line for the job
+func (job *sweepJob) args(outDir string, cores int, extra []string) []string {
+	args := []string{
+		"-image", job.Target,
+		"-geneCount", fmt.Sprintf("%d", job.GeneCount),
+		"-mutationRate", fmt.Sprintf("%v", job.MutationRate),
+		"-crossoverRate", fmt.Sprintf("%v", job.CrossoverRate),
+		"-popSize", fmt.Sprintf("%d", job.PopSize),
+		"-seed", fmt.Sprintf("%d", job.Seed),
+		"-runDir", outDir,
+		"-runID", job.ID,
+		"-cores", fmt.Sprintf("%d", cores),
+	}
+	return append(args, extra...)
+}
+
+// readResult fills in the job results from its manifest. It returns false if
+// the run never finished: there's no end time, or it was stopped early (by a
+// signal or the stop control) so a restarted sweep should run it again.
+func (job *sweepJob) readResult(jobDir string) bool {
+	m, err := LoadManifest(filepath.Join(jobDir, "manifest.json"))
+	if err != nil || m.EndTime == nil {
+		return false
+	}
+	job.Generations = m.Generations
+	job.FinalFitness = m.FinalFitness
+	job.StopReason = m.StopReason
+	job.Elapsed = m.EndTime.Sub(m.StartTime)
+	return m.StopReason != "signal"
+}
+
+// writeSweepIndex writes the index of all jobs (and their results so far)
+func writeSweepIndex(fileName string, jobs []*sweepJob) error {
+	tmpName := fileName + ".tmp"
+	f, err := os.Create(tmpName)
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(f)
+	w.Write([]string{
+		"JobID", "Target", "GeneCount", "MutationRate", "CrossoverRate", "PopSize", "Seed",
+		"Status", "Generations", "FinalFitness", "StopReason", "Seconds", "Dir",
+	})
+	for _, job := range jobs {
+		w.Write([]string{
+			job.ID,
+			job.Target,
+			fmt.Sprintf("%d", job.GeneCount),
+			fmt.Sprintf("%v", job.MutationRate),
+			fmt.Sprintf("%v", job.CrossoverRate),
+			fmt.Sprintf("%d", job.PopSize),
+			fmt.Sprintf("%d", job.Seed),
+			job.Status,
+			fmt.Sprintf("%d", job.Generations),
+			fmt.Sprintf("%.5f", job.FinalFitness),
+			job.StopReason,
+			fmt.Sprintf("%.0f", job.Elapsed.Seconds()),
+			job.ID,
+		})
+	}
+	w.Flush()
+
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, fileName)
+}
+
+// sweepMain is the entry point for the sweep command
+func sweepMain(args []string) {
+	flags := flag.NewFlagSet("evoimage sweep", flag.ExitOnError)
+	config := flags.String("config", "", "Sweep config file (JSON)")
+	outDir := flags.String("out", "", "Output directory: one run directory per job plus index.csv (default runs/<config name>)")
+	cores := flags.Int("cores", runtime.NumCPU(), "Total core budget for all jobs")
+	jobCores := flags.Int("jobCores", 1, "Cores used by each job")
+	binary := flags.String("bin", "", "evoimage binary used to run each job (default is this binary)")
+	dryRun := flags.Bool("dryRun", false, "Only print the jobs")
+
+	pcheck(flags.Parse(args))
+
+	if len(*config) < 1 {
+		pcheck(errors.New("Sweep config file is required"))
+	}
+	if *jobCores < 1 || *cores < *jobCores {
+		pcheck(errors.New("Need jobCores >= 1 and cores >= jobCores"))
+	}
+	if len(*outDir) < 1 {
+		base := filepath.Base(*config)
+		*outDir = filepath.Join("runs", strings.TrimSuffix(base, filepath.Ext(base)))
+	}
+	if len(*binary) < 1 {
+		exe, err := os.Executable()
+		pcheck(err)
+		*binary = exe
+	}
+
+	cfg, err := loadSweepConfig(*config)
+	pcheck(err)
+	jobs := cfg.Jobs()
+	parallel := *cores / *jobCores
+
+	if *dryRun {
+		for _, job := range jobs {
+			fmt.Printf("%s %s\n", *binary, strings.Join(job.args(*outDir, *jobCores, cfg.Args), " "))
+		}
+		return
+	}
+
+	pcheck(os.MkdirAll(*outDir, 0755))
+	indexName := filepath.Join(*outDir, "index.csv")
+	log.Printf("Sweep of %d jobs, %d at a time, in %s\n", len(jobs), parallel, *outDir)
+
+	// Jobs run in their own process groups, so Ctrl-C only reaches us: we
+	// stop starting new jobs and pass the signal on to the running ones (a
+	// second signal makes them exit right away, just like a single run)
+	ctl := &sweepControl{running: make(map[*exec.Cmd]bool)}
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		for range sigs {
+			log.Printf("Stopping sweep: waiting for running jobs\n")
+			ctl.stop()
+		}
+	}()
+
+	work := make(chan *sweepJob)
+	wait := sync.WaitGroup{}
+	wait.Add(parallel)
+	for w := 0; w < parallel; w++ {
+		go func() {
+			defer wait.Done()
+			for job := range work {
+				runSweepJob(job, *binary, *outDir, *jobCores, cfg.Args, ctl)
+
+				ctl.mu.Lock()
+				if err := writeSweepIndex(indexName, jobs); err != nil {
+					log.Printf("Could not write sweep index: %v\n", err)
+				}
+				ctl.mu.Unlock()
+			}
+		}()
+	}
+
+	for _, job := range jobs {
+		work <- job
+	}
+	close(work)
+	wait.Wait()
+
+	pcheck(writeSweepIndex(indexName, jobs))
+
+	counts := make(map[string]int)
+	for _, job := range jobs {
+		counts[job.Status]++
+	}
+	log.Printf("Sweep finished: %d done, %d failed, %d interrupted, %d skipped, %d pending. Index in %s\n",
+		counts["done"], counts["failed"], counts["interrupted"], counts["skipped"], counts["pending"], indexName)
+}
+
+// sweepControl is the state shared by the sweep's workers: the mutex also
+// guards the jobs
+type sweepControl struct {
+	mu       sync.Mutex
+	stopping bool
+	running  map[*exec.Cmd]bool
+}
+
+// stop stops new jobs from starting and sends SIGINT to the running ones
+func (ctl *sweepControl) stop() {
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+	ctl.stopping = true
+	for cmd := range ctl.running {
+		if err := cmd.Process.Signal(os.Interrupt); err != nil {
+			log.Printf("Could not stop job %d: %v\n", cmd.Process.Pid, err)
+		}
+	}
+}
+
+// start starts the job's process unless we are stopping
+func (ctl *sweepControl) start(cmd *exec.Cmd) (bool, error) {
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+	if ctl.stopping {
+		return false, nil
+	}
+	if err := cmd.Start(); err != nil {
+		return false, err
+	}
+	ctl.running[cmd] = true
+	return true, nil
+}
+
+// wait waits for a started job's process to finish
+func (ctl *sweepControl) wait(cmd *exec.Cmd) error {
+	err := cmd.Wait()
+	ctl.mu.Lock()
+	delete(ctl.running, cmd)
+	ctl.mu.Unlock()
+	return err
+}
+
+// runSweepJob runs a single job as a child process, unless the job already
+// finished in a previous sweep or we are stopping
+func runSweepJob(job *sweepJob, binary string, outDir string, cores int, extra []string, ctl *sweepControl) {
+	jobDir := filepath.Join(outDir, job.ID)
+
+	ctl.mu.Lock()
+	stop := ctl.stopping
+	finished := !stop && job.readResult(jobDir)
+	if finished {
+		job.Status = "skipped"
+	}
+	ctl.mu.Unlock()
+	if stop {
+		return
+	}
+	if finished {
+		log.Printf("Skipping %s: already finished\n", job.ID)
+		return
+	}
+
+	// An unfinished run's logs would be appended to (and its snapshots
+	// mixed in), so start the job from an empty directory
+	if _, err := os.Stat(jobDir); err == nil {
+		log.Printf("Clearing %s from an unfinished run\n", jobDir)
+		if err := os.RemoveAll(jobDir); err != nil {
+			log.Printf("Job %s failed: %v\n", job.ID, err)
+			ctl.mu.Lock()
+			job.Status = "failed"
+			ctl.mu.Unlock()
+			return
+		}
+	}
+	if err := os.MkdirAll(jobDir, 0755); err != nil {
+		log.Printf("Job %s failed: %v\n", job.ID, err)
+		ctl.mu.Lock()
+		job.Status = "failed"
+		ctl.mu.Unlock()
+		return
+	}
+
+	// Child output goes to the job's directory, so it is self-contained
+	output, err := os.Create(filepath.Join(jobDir, "output.log"))
+	if err == nil {
+		cmd := exec.Command(binary, job.args(outDir, cores, extra)...)
+		cmd.Stdout = output
+		cmd.Stderr = output
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+		var started bool
+		if started, err = ctl.start(cmd); started {
+			log.Printf("Starting %s\n", job.ID)
+			err = ctl.wait(cmd)
+		}
+		output.Close()
+		if !started && err == nil {
+			return // Stopping: the job stays pending
+		}
+	}
+
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+	finished = job.readResult(jobDir)
+	if err == nil && !finished && job.StopReason == "signal" {
+		log.Printf("Job %s was interrupted\n", job.ID)
+		job.Status = "interrupted"
+		return
+	}
+	if err != nil || !finished {
+		log.Printf("Job %s failed: %v\n", job.ID, err)
+		job.Status = "failed"
+		return
+	}
+	job.Status = "done"
+	log.Printf("Finished %s: %d generations, final fitness %.5f\n", job.ID, job.Generations, job.FinalFitness)
+}
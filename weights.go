@@ -0,0 +1,46 @@
+package main
+
+import (
+	"image"
+	"image/png"
+	"os"
+
+	"github.com/CraigKelly/evoimage/evo"
+)
+
+// loadWeights creates the fitness weights for a target: spec is saliency,
+// center, or the file name of a grayscale mask (PNG or JPEG)
+func loadWeights(spec string, target *evo.ImageTarget) ([]float64, error) {
+	switch spec {
+	case "saliency":
+		return evo.SaliencyWeights(target), nil
+	case "center":
+		return evo.CenterWeights(target), nil
+	}
+
+	f, err := os.Open(spec)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	mask, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	return evo.MaskWeights(target, mask), nil
+}
+
+// saveWeightImage writes the target's weights as a grayscale PNG so that you
+// can see what the fitness is focusing on
+func saveWeightImage(fileName string, target *evo.ImageTarget) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, target.WeightImage()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}